@@ -0,0 +1,222 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bounces
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abh1sheke/hermes-mailer/internal/logger"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/bounce"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/queue"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/suppress"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var stats, suppressions, verpTemplate, deliveryLog string
+var imapAddr, imapUser, imapPassword, imapMailbox, imapSecurity string
+
+// Cmd is the command definition for the "bounces" command.
+// "bounces" reads the delivery status notifications of bounced
+// emails, counting them in the stats of their senders and adding
+// hard-bounced receivers to the suppression list.
+var Cmd = &cobra.Command{
+	Use:   "bounces [path]...",
+	Short: "Process the bounce notifications of sent emails",
+	Long: "Process the bounce notifications of sent emails.\n\n" +
+		"Notifications are read from mbox files, Maildirs, directories of .eml files\n" +
+		"and, if --imap is set, the unseen messages of an IMAP mailbox, which are\n" +
+		"marked as seen once their bounces are saved. Files are not marked, so each\n" +
+		"should only be processed once.",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		level := cmd.Parent().Flag("log-level").Value.String()
+		n, _ := strconv.ParseInt(level, 10, 8)
+		if err := logger.Init(zerolog.Level(n)); err != nil {
+			return err
+		}
+
+		if len(args) == 0 && imapAddr == "" {
+			return errors.New("no paths given, and no imap server set")
+		}
+
+		list, err := suppress.Open(suppressions)
+		if err != nil {
+			return err
+		}
+
+		var verp *mailer.VERP
+		if verpTemplate != "" {
+			if verp, err = mailer.ParseVERP(verpTemplate); err != nil {
+				return err
+			}
+		}
+
+		sent, err := readDeliveryLog(deliveryLog, verp)
+		if err != nil {
+			return err
+		}
+
+		var notifications int
+		var bounces []*bounce.Bounce
+		commit := func() error { return nil }
+		process := func(msg []byte) error {
+			report, err := bounce.Parse(bytes.NewReader(msg))
+			if errors.Is(err, bounce.ErrNotDSN) {
+				return err
+			} else if err != nil {
+				log.Warn().Err(err).Msg("skipping malformed message")
+				return bounce.ErrNotDSN
+			}
+
+			notifications++
+			bounces = append(bounces, report.Bounces(verp)...)
+			return nil
+		}
+
+		for _, path := range args {
+			if err := bounce.Walk(path, process); err != nil {
+				return err
+			}
+		}
+
+		if imapAddr != "" {
+			sec, err := mailer.ParseSecurity(imapSecurity)
+			if err != nil {
+				return err
+			}
+
+			src := &bounce.IMAPSource{
+				Addr:     imapAddr,
+				Username: imapUser,
+				Password: imapPassword,
+				Mailbox:  imapMailbox,
+				Security: sec,
+			}
+			if commit, err = src.Walk(process); err != nil {
+				return err
+			}
+		}
+
+		counts := make(map[string]uint)
+		var hard int
+		now := time.Now()
+		for _, b := range bounces {
+			if entry, ok := sent[b.MessageID]; ok {
+				b.Sender, b.Receiver, b.Campaign = entry.Sender, entry.Receiver, entry.Campaign
+			}
+
+			log.Info().
+				Str("sender", b.Sender).
+				Str("receiver", b.Receiver).
+				Str("address", b.Address).
+				Str("campaign", b.Campaign).
+				Str("status", b.Status).
+				Str("diagnostic", b.Diagnostic).
+				Bool("hard", b.Hard).
+				Msg("bounce")
+
+			if b.Sender != "" {
+				counts[b.Sender]++
+			}
+			if b.Hard {
+				hard++
+				list.Add(b.Address, suppress.Bounced, now)
+			}
+		}
+
+		if len(counts) > 0 {
+			if err := queue.RecordBounces(stats, counts); err != nil {
+				return err
+			}
+		}
+		if hard > 0 {
+			if err := list.Save(); err != nil {
+				return err
+			}
+		}
+
+		if err := commit(); err != nil {
+			return err
+		}
+
+		printSummary(notifications, len(bounces), hard, counts)
+		return nil
+	},
+}
+
+// readDeliveryLog returns the entries of the delivery log written by
+// 'send', by Message-ID, registering their receivers with verp if it is
+// not nil. A missing log is treated as an empty one.
+func readDeliveryLog(file string, verp *mailer.VERP) (map[string]*queue.DeliveryEntry, error) {
+	entries, err := queue.ReadDeliveryLog(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		if verp != nil {
+			log.Warn().Str("file", file).Msg("no delivery log, bounces cannot be attributed by their envelope sender")
+		}
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	sent := make(map[string]*queue.DeliveryEntry, len(entries))
+	for _, e := range entries {
+		if verp != nil {
+			verp.Register(e.Receiver, e.Campaign)
+		}
+		if e.MessageID != "" {
+			sent[e.MessageID] = e
+		}
+	}
+	return sent, nil
+}
+
+func printSummary(notifications, bounces, hard int, counts map[string]uint) {
+	fmt.Printf("\nprocessed %d notification(s): %d bounce(s), %d hard\n", notifications, bounces, hard)
+
+	senders := make([]string, 0, len(counts))
+	for sender := range counts {
+		senders = append(senders, sender)
+	}
+	sort.Strings(senders)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, sender := range senders {
+		fmt.Fprintf(w, "  %s\t%d\n", sender, counts[sender])
+	}
+	w.Flush()
+}
+
+func init() {
+	Cmd.Flags().StringVar(&stats, "stats", "stats.csv", "Path to the stats of the run, as saved by 'send', in which bounces are counted")
+	Cmd.Flags().StringVar(&suppressions, "suppressions", "suppressions.csv", "Path to the suppression list to which hard-bounced addresses are added")
+	Cmd.Flags().StringVar(&deliveryLog, "delivery-log", "deliveries.jsonl", "Path to the delivery log written by 'send', with which bounces are attributed by their Message-ID")
+	Cmd.Flags().StringVar(&verpTemplate, "verp", "", "Sets the envelope sender template given to 'send --verp', with which bounces are attributed to their receiver and campaign in the delivery log")
+	Cmd.Flags().StringVar(&imapAddr, "imap", "", "Sets the address (host:port) of an IMAP server from which bounces are read")
+	Cmd.Flags().StringVar(&imapUser, "imap-user", "", "Sets the username of the IMAP mailbox")
+	Cmd.Flags().StringVar(&imapPassword, "imap-password", "", "Sets the password of the IMAP mailbox")
+	Cmd.Flags().StringVar(&imapMailbox, "imap-mailbox", "INBOX", "Sets the IMAP mailbox from which bounces are read")
+	Cmd.Flags().StringVar(&imapSecurity, "imap-security", "tls", "Sets the connection security of the IMAP server ('starttls', 'starttls-required', 'tls' or 'none')")
+}
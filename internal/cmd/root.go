@@ -15,7 +15,12 @@
 package cmd
 
 import "github.com/spf13/cobra"
+import "github.com/abh1sheke/hermes-mailer/internal/cmd/bounces"
 import "github.com/abh1sheke/hermes-mailer/internal/cmd/send"
+import "github.com/abh1sheke/hermes-mailer/internal/cmd/sink"
+import "github.com/abh1sheke/hermes-mailer/internal/cmd/suppress"
+import "github.com/abh1sheke/hermes-mailer/internal/cmd/unsubscribe"
+import "github.com/abh1sheke/hermes-mailer/internal/cmd/validate"
 
 var rootCmd = &cobra.Command{
 	Use:   "hermes",
@@ -27,6 +32,11 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(send.Cmd)
+	rootCmd.AddCommand(bounces.Cmd)
+	rootCmd.AddCommand(sink.Cmd)
+	rootCmd.AddCommand(suppress.Cmd)
+	rootCmd.AddCommand(unsubscribe.Cmd)
+	rootCmd.AddCommand(validate.Cmd)
 
 	rootCmd.PersistentFlags().Uint8P("log-level", "l", 1, "Sets the log level")
 }
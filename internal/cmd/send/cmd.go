@@ -15,18 +15,40 @@
 package send
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/abh1sheke/hermes-mailer/internal/logger"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
 	"github.com/abh1sheke/hermes-mailer/pkg/mailer/queue"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/unsubscribe"
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
 var senders, receivers, subject, host, readReceipts string
-var textContent, htmlContent string
-var workers uint8
-var perDay, perMinute uint16
+var textContent, htmlContent, preheader string
+var templateDir string
+var journal, resume, caCert, dryRunDir, security, auth, dkimKeys, suppressions string
+var tokenURL, clientID, clientSecret string
+var unsubscribeURL, unsubscribeMailto, unsubscribeSecret string
+var campaign, verp, deliveryLog string
+var quotaLog, dailyReset, timezone string
+var port uint16
+var attachments []string
+var maxAttachmentMB uint
+var dryRun, mbox, embedImages bool
+var workers, retries uint8
+var retryDelay time.Duration
+var perSecond, perMinute, perHour, perDay, batchSize uint16
 
 // Cmd is the command defenition for the "multi" command.
 // "multi" allows users to send email messages from multiple senders.
@@ -35,44 +57,189 @@ var Cmd = &cobra.Command{
 	Short:        "Send email messages from multiple senders",
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		log := cmd.Parent().Flag("log-level").Value.String()
-		n, _ := strconv.ParseInt(log, 10, 8)
+		level := cmd.Parent().Flag("log-level").Value.String()
+		n, _ := strconv.ParseInt(level, 10, 8)
 		if err := logger.Init(zerolog.Level(n)); err != nil {
 			return err
 		}
 
-		q, err := queue.New(
-			senders,
-			receivers,
-			subject,
-			host,
-			textContent,
+		tlsConfig, err := loadCACert(caCert)
+		if err != nil {
+			return err
+		}
+
+		sec, err := mailer.ParseSecurity(security)
+		if err != nil {
+			return err
+		}
+
+		a, err := mailer.ParseAuth(auth)
+		if err != nil {
+			return err
+		}
+
+		var oauth2 *mailer.OAuth2Config
+		if tokenURL != "" {
+			oauth2 = &mailer.OAuth2Config{
+				TokenURL:     tokenURL,
+				ClientID:     clientID,
+				ClientSecret: clientSecret,
+			}
+		}
+
+		rolling, err := queue.ParseQuotaReset(dailyReset)
+		if err != nil {
+			return err
+		}
+
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return err
+		}
+
+		var unsub *unsubscribe.Config
+		if unsubscribeURL != "" || unsubscribeMailto != "" {
+			unsub = &unsubscribe.Config{
+				BaseURL: unsubscribeURL,
+				Mailto:  unsubscribeMailto,
+				Secret:  []byte(unsubscribeSecret),
+			}
+		}
+
+		opts := []queue.OptFunc{
 			queue.WithHTML(htmlContent),
+			queue.WithTemplateDir(templateDir),
+			queue.WithPreheader(preheader),
+			queue.WithEmbeddedImages(embedImages),
+			queue.WithAttachments(attachments...),
+			queue.WithMaxAttachmentSize(int64(maxAttachmentMB) << 20),
+			queue.WithRateSecond(perSecond),
 			queue.WithRateMinute(perMinute),
+			queue.WithRateHourly(perHour),
 			queue.WithRateDaily(perDay),
+			queue.WithDailyReset(rolling, loc),
+			queue.WithQuotaLog(quotaLog),
+			queue.WithBatchSize(batchSize),
 			queue.WithWorkers(workers),
 			queue.WithReadReceipts(readReceipts),
-		)
+			queue.WithRetries(retries, retryDelay),
+			queue.WithPort(port),
+			queue.WithSecurity(sec),
+			queue.WithAuth(a),
+			queue.WithOAuth2(oauth2),
+			queue.WithTLSConfig(tlsConfig),
+			queue.WithDKIM(dkimKeys),
+			queue.WithJournal(journal),
+			queue.WithDeliveryLog(deliveryLog),
+			queue.WithResume(resume),
+			queue.WithSuppressionList(suppressions),
+			queue.WithUnsubscribe(unsub),
+			queue.WithCampaign(campaign),
+			queue.WithVERP(verp),
+		}
+
+		if dryRun {
+			opts = append(opts, queue.WithDryRun(dryRunDir, mbox))
+		}
+
+		q, err := queue.New(senders, receivers, subject, host, textContent, opts...)
 		if err != nil {
 			return err
 		}
-		return q.Run()
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		done := make(chan struct{})
+		defer close(done)
+
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-done:
+				return
+			}
+			// restore the default behaviour so that a second signal
+			// terminates the process immediately.
+			stop()
+			log.Warn().Msg("shutting down, waiting for in-flight emails to be sent...")
+		}()
+
+		return q.RunContext(ctx)
 	},
 }
 
 func init() {
 	Cmd.Flags().StringVarP(&senders, "senders", "s", "", "Path to file containing senders")
 	Cmd.Flags().StringVarP(&receivers, "receivers", "r", "", "Path to file containing receivers")
-	Cmd.Flags().StringVarP(&subject, "subject", "S", "", "Sets the subject for the email messages")
+	Cmd.Flags().StringVarP(&subject, "subject", "S", "", "Sets the subject for the email messages, which may reference the variables of receivers, e.g. 'Hi {{.name}}'")
+	Cmd.Flags().StringVar(&preheader, "preheader", "", "Sets the preview text shown after the subject, hidden in the html content, which may reference the variables of receivers")
 	Cmd.Flags().StringVar(&host, "host", "", "Sets the SMTP host server for the senders")
+	Cmd.Flags().Uint16Var(&port, "port", 587, "Sets the port of the SMTP host server")
+	Cmd.Flags().StringVar(&security, "security", "starttls", "Sets the connection security ('starttls', 'starttls-required', 'tls' or 'none')")
+	Cmd.Flags().StringVar(&auth, "auth", "plain", "Sets the authentication mechanism ('plain', 'login', 'cram-md5', 'xoauth2' or 'oauthbearer')")
+	Cmd.Flags().StringVar(&tokenURL, "oauth-token-url", "", "Sets the OAuth 2.0 token endpoint, treating sender passwords as refresh tokens")
+	Cmd.Flags().StringVar(&clientID, "oauth-client-id", "", "Sets the OAuth 2.0 client ID used with the token endpoint")
+	Cmd.Flags().StringVar(&clientSecret, "oauth-client-secret", "", "Sets the OAuth 2.0 client secret used with the token endpoint")
 	Cmd.Flags().StringVarP(&readReceipts, "read-receipts", "R", "", "Sets the email to which read-receipts are sent")
 	Cmd.Flags().StringVarP(&textContent, "text", "t", "", "Path to the file containing plaintext email content")
 	Cmd.Flags().StringVarP(&htmlContent, "html", "", "", "Path to the file containig html email content")
+	Cmd.Flags().StringVar(&templateDir, "template-dir", "", "Path to a directory of layouts and partials (.txt, .tmpl and .html files) which the email content may include")
+	Cmd.Flags().StringVar(&caCert, "ca-cert", "", "Path to a PEM file of additional certificates to trust, such as the one written by 'hermes sink'")
+	Cmd.Flags().StringVar(&dkimKeys, "dkim", "", "Path to a CSV file of DKIM keys (domain, selector, key) with which emails are signed")
+	Cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Simulates the run, writing the emails and the send plan to disk instead of sending them")
+	Cmd.Flags().StringVar(&dryRunDir, "dry-run-dir", "dry-run", "Path to the directory to which the output of a dry run is written")
+	Cmd.Flags().BoolVar(&mbox, "mbox", false, "Writes the emails of a dry run to a single mbox file instead of .eml files")
+	Cmd.Flags().StringVar(&journal, "journal", "journal.csv", "Path to the file in which the outcome of each email is recorded")
+	Cmd.Flags().StringVar(&suppressions, "suppressions", "suppressions.csv", "Path to the suppression list of addresses which are never sent to, as managed by 'hermes suppress'")
+	Cmd.Flags().StringVar(&unsubscribeURL, "unsubscribe-url", "", "Sets the public URL of 'hermes serve-unsubscribe', adding signed List-Unsubscribe links to every email")
+	Cmd.Flags().StringVar(&unsubscribeMailto, "unsubscribe-mailto", "", "Sets an address to which unsubscribe requests may be emailed, added to the List-Unsubscribe header")
+	Cmd.Flags().StringVar(&unsubscribeSecret, "unsubscribe-secret", "", "Sets the secret with which unsubscribe links are signed, shared with 'hermes serve-unsubscribe'")
+	Cmd.Flags().StringVar(&campaign, "campaign", "", "Sets the name of the campaign, with which bounces are attributed")
+	Cmd.Flags().StringVar(&verp, "verp", "", "Sets a template for per-email envelope senders, e.g. 'bounces+{{hash}}@example.com', so that bounces can be attributed to their receiver")
+	Cmd.Flags().StringVar(&deliveryLog, "delivery-log", "deliveries.jsonl", "Path to the JSONL file in which every attempt to send an email is logged, with its Message-ID and the server's reply, across runs")
+	Cmd.Flags().StringVar(&resume, "resume", "", "Resumes a previous run from the given journal, skipping delivered receivers")
+
+	Cmd.Flags().BoolVar(&embedImages, "embed-images", false, "Embeds the local images referenced by the html content as inline attachments")
+	Cmd.Flags().StringSliceVar(&attachments, "attach", nil, "Path to a file to attach to every email (may be repeated)")
+	Cmd.Flags().UintVar(&maxAttachmentMB, "max-attachment-size", 10, "Sets the maximum total size of the attachments of an email, in MB")
 
 	Cmd.Flags().Uint8VarP(&workers, "workers", "", 2, "Sets the number of simultaneous send operations")
-	Cmd.Flags().Uint16VarP(&perDay, "per-day", "", 100, "Sets the 'per day' email send-rate for each sender")
-	Cmd.Flags().Uint16VarP(&perMinute, "per-minute", "", 1, "Sets the 'per minute' email send-rate for each sender")
+	Cmd.Flags().Uint16VarP(&perDay, "per-day", "", 100, "Sets the 'per day' email send-rate for each sender (0 for unlimited)")
+	Cmd.Flags().StringVar(&dailyReset, "daily-reset", "calendar", "Sets how the 'per day' rate is counted ('calendar', resetting at midnight, or 'rolling' over the last 24 hours)")
+	Cmd.Flags().StringVar(&timezone, "timezone", "Local", "Sets the timezone in which calendar days are counted, e.g. 'America/New_York'")
+	Cmd.Flags().StringVar(&quotaLog, "quota-log", "quota.csv", "Path to the file in which sends are kept, so that they count against the daily rates of later runs")
+	Cmd.Flags().Uint16Var(&perHour, "per-hour", 0, "Sets the 'per hour' email send-rate for each sender (0 for unlimited)")
+	Cmd.Flags().Uint16VarP(&perMinute, "per-minute", "", 1, "Sets the 'per minute' email send-rate for each sender (0 for unlimited)")
+	Cmd.Flags().Uint16Var(&perSecond, "per-second", 0, "Sets the 'per second' email send-rate for each sender (0 for unlimited)")
+	Cmd.Flags().Uint16Var(&batchSize, "batch-size", 10, "Sets the maximum number of emails sent by a sender over a single connection")
+	Cmd.Flags().Uint8Var(&retries, "retries", 5, "Sets the maximum number of attempts for emails failing with transient errors")
+	Cmd.Flags().DurationVar(&retryDelay, "retry-delay", 1*time.Minute, "Sets the delay before the first retry, doubled for each subsequent attempt")
 
 	Cmd.MarkFlagRequired("senders")
 	Cmd.MarkFlagsRequiredTogether("senders", "receivers", "subject", "host", "text", "html")
+	Cmd.MarkFlagsMutuallyExclusive("dry-run", "resume")
+}
+
+// loadCACert returns a TLS configuration which trusts the certificates
+// in file in addition to the system's, or nil if file is empty.
+func loadCACert(file string) (*tls.Config, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in: %q", file)
+	}
+
+	return &tls.Config{RootCAs: pool}, nil
 }
@@ -0,0 +1,107 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"github.com/abh1sheke/hermes-mailer/internal/logger"
+	"github.com/abh1sheke/hermes-mailer/pkg/sink"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+var addr, dir, format, certFile string
+var starttls, implicitTLS bool
+
+// Cmd is the command definition for the "sink" command.
+// "sink" starts a local SMTP server which accepts and stores every
+// message, so that send operations can be rehearsed safely.
+var Cmd = &cobra.Command{
+	Use:          "sink",
+	Short:        "Start a local SMTP server which stores every message it receives",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		level := cmd.Parent().Flag("log-level").Value.String()
+		n, _ := strconv.ParseInt(level, 10, 8)
+		if err := logger.Init(zerolog.Level(n)); err != nil {
+			return err
+		}
+
+		f, err := sink.ParseFormat(format)
+		if err != nil {
+			return err
+		}
+
+		var tlsConfig *tls.Config
+		if starttls || implicitTLS {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return err
+			}
+
+			cert, pem, err := sink.SelfSignedCert(host)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(certFile, pem, 0o644); err != nil {
+				return err
+			}
+			tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		srv := sink.NewServer(addr, dir, f, tlsConfig)
+		srv.ImplicitTLS = implicitTLS
+		if err := srv.ListenAndServe(ctx); err != nil {
+			return err
+		}
+
+		printSummary(srv.Summary())
+		return nil
+	},
+}
+
+func printSummary(s sink.Summary) {
+	fmt.Printf("\nreceived %d message(s) for %d recipient(s)\n", s.Messages, s.Recipients)
+
+	senders := make([]string, 0, len(s.Senders))
+	for sender := range s.Senders {
+		senders = append(senders, sender)
+	}
+	sort.Strings(senders)
+
+	for _, sender := range senders {
+		fmt.Printf("  %-40s %d\n", sender, s.Senders[sender])
+	}
+}
+
+func init() {
+	Cmd.Flags().StringVar(&addr, "addr", "localhost:587", "Sets the address on which the SMTP server listens")
+	Cmd.Flags().StringVarP(&dir, "dir", "d", "sink", "Path to the directory in which received messages are stored")
+	Cmd.Flags().StringVarP(&format, "format", "f", "maildir", "Sets the format of stored messages ('maildir' or 'eml')")
+	Cmd.Flags().BoolVar(&starttls, "starttls", true, "Enables STARTTLS with a self-signed certificate")
+	Cmd.Flags().BoolVar(&implicitTLS, "tls", false, "Serves connections over implicit TLS with a self-signed certificate, instead of STARTTLS")
+	Cmd.Flags().StringVar(&certFile, "cert", "sink.pem", "Path to which the self-signed certificate is written, for use with 'send --ca-cert'")
+}
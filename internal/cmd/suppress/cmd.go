@@ -0,0 +1,163 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suppress
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abh1sheke/hermes-mailer/internal/logger"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/suppress"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+var file, reason string
+
+// Cmd is the command definition for the "suppress" command.
+// "suppress" manages the list of addresses which "send" never
+// sends to, such as those which have unsubscribed or bounced.
+var Cmd = &cobra.Command{
+	Use:   "suppress",
+	Short: "Manage the list of addresses which are never sent to",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		level := cmd.Flag("log-level").Value.String()
+		n, _ := strconv.ParseInt(level, 10, 8)
+		return logger.Init(zerolog.Level(n))
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var addCmd = &cobra.Command{
+	Use:          "add <email>...",
+	Short:        "Add addresses to the suppression list",
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return update(func(l *suppress.List) int {
+			var n int
+			now := time.Now()
+			for _, address := range args {
+				if l.Add(address, reason, now) {
+					n++
+				}
+			}
+			fmt.Printf("added %d address(es)\n", n)
+			return n
+		})
+	},
+}
+
+var removeCmd = &cobra.Command{
+	Use:          "remove <email>...",
+	Short:        "Remove addresses from the suppression list",
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return update(func(l *suppress.List) int {
+			var n int
+			for _, address := range args {
+				if l.Remove(address) {
+					n++
+				}
+			}
+			fmt.Printf("removed %d address(es)\n", n)
+			return n
+		})
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <file>...",
+	Short: "Add the addresses of CSV files to the suppression list",
+	Long: "Add the addresses of CSV files to the suppression list.\n\n" +
+		"The files must have an 'email' column, and may have a 'reason' column, so\n" +
+		"receiver files and the output of 'send' can be imported as they are.",
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var entries []*suppress.Entry
+		for _, f := range args {
+			e, err := mailer.ReadFile[suppress.Entry](f)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, e...)
+		}
+
+		return update(func(l *suppress.List) int {
+			var n int
+			now := time.Now()
+			for _, e := range entries {
+				r := e.Reason
+				if r == "" {
+					r = reason
+				}
+				if l.Add(e.Email, r, now) {
+					n++
+				}
+			}
+			fmt.Printf("imported %d new address(es) of %d\n", n, len(entries))
+			return n
+		})
+	},
+}
+
+var listCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "List the suppressed addresses",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		l, err := suppress.Open(file)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, e := range l.Entries() {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", e.Email, e.Reason, e.Added.Format(time.DateTime))
+		}
+		return w.Flush()
+	},
+}
+
+// update applies fn to the suppression list, saving it if fn
+// reports any changes.
+func update(fn func(l *suppress.List) int) error {
+	l, err := suppress.Open(file)
+	if err != nil {
+		return err
+	}
+	if fn(l) == 0 {
+		return nil
+	}
+	return l.Save()
+}
+
+func init() {
+	Cmd.PersistentFlags().StringVarP(&file, "file", "f", "suppressions.csv", "Path to the suppression list")
+
+	addCmd.Flags().StringVar(&reason, "reason", suppress.Manual, "Sets the reason for suppressing the addresses")
+	importCmd.Flags().StringVar(&reason, "reason", suppress.Manual, "Sets the reason for addresses without a 'reason' column")
+
+	Cmd.AddCommand(addCmd, removeCmd, importCmd, listCmd)
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unsubscribe
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/abh1sheke/hermes-mailer/internal/logger"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/suppress"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/unsubscribe"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var addr, secret, suppressions string
+
+// Cmd is the command definition for the "serve-unsubscribe" command.
+// "serve-unsubscribe" serves the unsubscribe links added by "send",
+// adding the addresses of receivers who unsubscribe to the
+// suppression list.
+var Cmd = &cobra.Command{
+	Use:          "serve-unsubscribe",
+	Short:        "Serve the unsubscribe links of sent emails",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		level := cmd.Parent().Flag("log-level").Value.String()
+		n, _ := strconv.ParseInt(level, 10, 8)
+		if err := logger.Init(zerolog.Level(n)); err != nil {
+			return err
+		}
+
+		if secret == "" {
+			return errors.New("a secret is required to verify unsubscribe links")
+		}
+		config := &unsubscribe.Config{Secret: []byte(secret)}
+
+		// the list is reopened for every request, so that changes made by
+		// 'hermes suppress' while the server runs are not overwritten.
+		var mu sync.Mutex
+		handler := config.Handler(func(address string) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			l, err := suppress.Open(suppressions)
+			if err != nil {
+				return err
+			}
+			if !l.Add(address, suppress.Unsubscribed, time.Now()) {
+				return nil
+			}
+			log.Info().Str("email", address).Msg("unsubscribed")
+			return l.Save()
+		})
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			<-ctx.Done()
+			shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(shutdown)
+		}()
+
+		log.Info().Str("addr", addr).Msg("serving unsubscribe links")
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	Cmd.Flags().StringVar(&addr, "addr", "localhost:8080", "Sets the address on which the HTTP server listens")
+	Cmd.Flags().StringVar(&secret, "secret", "", "Sets the secret with which unsubscribe links are signed, as given to 'send --unsubscribe-secret'")
+	Cmd.Flags().StringVarP(&suppressions, "suppressions", "f", "suppressions.csv", "Path to the suppression list to which unsubscribed addresses are added")
+}
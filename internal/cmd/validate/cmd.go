@@ -0,0 +1,105 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/abh1sheke/hermes-mailer/internal/logger"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/queue"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/unsubscribe"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+var receivers, subject, preheader, textContent, htmlContent, unsubscribeURL string
+var templateDir string
+
+// Cmd is the command definition for the "validate" command.
+// "validate" checks the variables of each receiver against those
+// referenced by the templates, before anything is sent.
+var Cmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the variables of the receivers against the templates",
+	Long: "Check the variables of the receivers against the templates.\n\n" +
+		"Reports the variables referenced by the templates which each receiver is\n" +
+		"missing, and the variables of each receiver which are not used, failing if\n" +
+		"any are missing. 'send' performs the same check before sending.",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		level := cmd.Parent().Flag("log-level").Value.String()
+		n, _ := strconv.ParseInt(level, 10, 8)
+		if err := logger.Init(zerolog.Level(n)); err != nil {
+			return err
+		}
+
+		opts := []queue.OptFunc{
+			queue.WithHTML(htmlContent),
+			queue.WithPreheader(preheader),
+			queue.WithTemplateDir(templateDir),
+		}
+		if unsubscribeURL != "" {
+			// links are not signed while validating, so any secret will do
+			opts = append(opts, queue.WithUnsubscribe(&unsubscribe.Config{BaseURL: unsubscribeURL, Secret: []byte("validate")}))
+		}
+
+		q, err := queue.New("", receivers, subject, "", textContent, opts...)
+		if err != nil {
+			return err
+		}
+
+		problems := q.Validate()
+		if len(problems) == 0 {
+			fmt.Println("the variables of every receiver match the templates")
+			return nil
+		}
+
+		var missing int
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ROW\tRECEIVER\tMISSING\tUNUSED")
+		for _, p := range problems {
+			if len(p.Missing) > 0 {
+				missing++
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", p.Row, p.Receiver, strings.Join(p.Missing, ";"), strings.Join(p.Unused, ";"))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if missing > 0 {
+			return fmt.Errorf("%d receivers are missing variables", missing)
+		}
+		return nil
+	},
+}
+
+func init() {
+	Cmd.Flags().StringVarP(&receivers, "receivers", "r", "", "Path to file containing receivers")
+	Cmd.Flags().StringVarP(&subject, "subject", "S", "", "Sets the subject for the email messages")
+	Cmd.Flags().StringVar(&preheader, "preheader", "", "Sets the preview text shown after the subject")
+	Cmd.Flags().StringVarP(&textContent, "text", "t", "", "Path to the file containing plaintext email content")
+	Cmd.Flags().StringVar(&htmlContent, "html", "", "Path to the file containig html email content")
+	Cmd.Flags().StringVar(&templateDir, "template-dir", "", "Path to a directory of layouts and partials which the email content may include")
+	Cmd.Flags().StringVar(&unsubscribeURL, "unsubscribe-url", "", "Sets the public URL of 'hermes serve-unsubscribe', which provides the 'unsubscribe_url' variable")
+
+	Cmd.MarkFlagRequired("receivers")
+	Cmd.MarkFlagRequired("text")
+}
@@ -0,0 +1,246 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bounce reads delivery status notifications (RFC 3464) from
+// mailboxes and attributes them to the emails which bounced.
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+)
+
+// ErrNotDSN is returned by [Parse] for messages which are not delivery
+// status notifications, such as auto-replies.
+var ErrNotDSN = errors.New("message is not a delivery status notification")
+
+// Recipient is the delivery status of a single recipient of a bounced
+// email.
+type Recipient struct {
+	// Address is the final recipient, which may differ from the
+	// original one if the email was forwarded.
+	Address    string
+	Original   string
+	Action     string
+	Status     string
+	Diagnostic string
+}
+
+// Failed reports whether delivery to the recipient has been given up,
+// as opposed to being delayed, relayed or delivered.
+func (r *Recipient) Failed() bool {
+	return strings.EqualFold(r.Action, "failed")
+}
+
+// Hard reports whether the recipient failed permanently, so that
+// emails should no longer be sent to it.
+func (r *Recipient) Hard() bool {
+	return r.Failed() && strings.HasPrefix(r.Status, "5.")
+}
+
+// Report is a parsed delivery status notification.
+type Report struct {
+	// To is the address the notification was sent to, which is the
+	// envelope sender of the bounced email.
+	To string
+	// From and MessageID are taken from the headers of the bounced
+	// email, if the notification includes them.
+	From       string
+	MessageID  string
+	Recipients []*Recipient
+}
+
+// Bounce attributes a failed recipient of a [Report] to the sender,
+// receiver and campaign of the email which bounced.
+type Bounce struct {
+	// Sender is empty if it cannot be determined from the report.
+	Sender string
+	// Receiver is the receiver the email was sent to, while Address is
+	// the address which failed, which may be one of its Cc or Bcc
+	// addresses.
+	Receiver   string
+	Address    string
+	Campaign   string
+	MessageID  string
+	Status     string
+	Diagnostic string
+	Hard       bool
+}
+
+// Bounces returns the recipients of r for which delivery failed.
+//
+// If verp is not nil and the notification was sent to an envelope sender
+// built by it for a registered receiver, the receiver and campaign are
+// decoded from it. Otherwise
+// the receiver is the failed address, and the sender is the recipient of
+// the notification. In either case, the sender is taken from the headers
+// of the bounced email if they are included.
+func (r *Report) Bounces(verp *mailer.VERP) []*Bounce {
+	sender, receiver, campaign := r.To, "", ""
+	if verp != nil {
+		var ok bool
+		if receiver, campaign, ok = verp.Decode(r.To); ok {
+			sender = ""
+		}
+	}
+	if r.From != "" {
+		sender = r.From
+	}
+
+	var bounces []*Bounce
+	for _, rcpt := range r.Recipients {
+		if !rcpt.Failed() {
+			continue
+		}
+
+		address := rcpt.Original
+		if address == "" {
+			address = rcpt.Address
+		}
+		b := &Bounce{
+			Sender:     sender,
+			Receiver:   receiver,
+			Address:    address,
+			Campaign:   campaign,
+			MessageID:  r.MessageID,
+			Status:     rcpt.Status,
+			Diagnostic: rcpt.Diagnostic,
+			Hard:       rcpt.Hard(),
+		}
+		if b.Receiver == "" {
+			b.Receiver = address
+		}
+		bounces = append(bounces, b)
+	}
+	return bounces
+}
+
+// Parse parses a delivery status notification from r. Messages which are
+// not multipart/report messages of the delivery-status type fail with
+// [ErrNotDSN].
+func Parse(r io.Reader) (*Report, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "delivery-status") {
+		return nil, ErrNotDSN
+	}
+
+	report := &Report{To: address(msg.Header.Get("To"))}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		b, err := readPart(p)
+		if err != nil {
+			return nil, err
+		}
+
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status":
+			if report.Recipients, err = parseStatus(b); err != nil {
+				return nil, err
+			}
+		case "message/rfc822", "message/global", "text/rfc822-headers", "message/global-headers":
+			h, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(b))).ReadMIMEHeader()
+			if err != nil && len(h) == 0 {
+				continue
+			}
+			report.From = address(h.Get("From"))
+			report.MessageID = strings.TrimSpace(h.Get("Message-Id"))
+		}
+	}
+
+	if len(report.Recipients) == 0 {
+		return nil, ErrNotDSN
+	}
+	return report, nil
+}
+
+// readPart reads the body of p, decoding it if it is base64 encoded.
+// Quoted-printable bodies are decoded by [multipart.Reader] itself.
+func readPart(p *multipart.Part) ([]byte, error) {
+	var r io.Reader = p
+	if strings.EqualFold(p.Header.Get("Content-Transfer-Encoding"), "base64") {
+		r = base64.NewDecoder(base64.StdEncoding, p)
+	}
+	return io.ReadAll(r)
+}
+
+// parseStatus parses the fields of a message/delivery-status part, which
+// are a block of per-message fields followed by a block for each
+// recipient, separated by blank lines.
+func parseStatus(b []byte) ([]*Recipient, error) {
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(b)))
+
+	var recipients []*Recipient
+	for first := true; ; first = false {
+		h, err := tp.ReadMIMEHeader()
+		if len(h) > 0 && !first {
+			recipients = append(recipients, &Recipient{
+				Address:    address(typed(h.Get("Final-Recipient"))),
+				Original:   address(typed(h.Get("Original-Recipient"))),
+				Action:     strings.ToLower(strings.TrimSpace(h.Get("Action"))),
+				Status:     strings.TrimSpace(h.Get("Status")),
+				Diagnostic: typed(h.Get("Diagnostic-Code")),
+			})
+		}
+
+		if err == io.EOF {
+			return recipients, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// typed strips the type of a typed field, such as "rfc822; a@b.com".
+func typed(v string) string {
+	if _, value, ok := strings.Cut(v, ";"); ok {
+		v = value
+	}
+	return strings.TrimSpace(v)
+}
+
+// address returns the address of an address field, lower-cased.
+func address(v string) string {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return ""
+	}
+	if a, err := mail.ParseAddress(v); err == nil {
+		v = a.Address
+	}
+	return strings.ToLower(strings.Trim(v, "<>"))
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bounce
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+)
+
+// dsn returns a delivery status notification for an email from sender
+// to receiver, failed with status.
+func dsn(sender, receiver, status string) string {
+	return strings.ReplaceAll(`From: Mail Delivery System <MAILER-DAEMON@mx.example.net>
+To: `+sender+`
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="b1"
+
+--b1
+Content-Type: text/plain
+
+Your message could not be delivered.
+
+--b1
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.net
+
+Final-Recipient: rfc822; `+receiver+`
+Action: failed
+Status: `+status+`
+Diagnostic-Code: smtp; 550 5.1.1 User unknown
+
+Final-Recipient: rfc822; delayed@example.net
+Action: delayed
+Status: 4.4.1
+
+--b1
+Content-Type: text/rfc822-headers
+
+From: Sender <`+sender+`>
+To: `+receiver+`
+Message-Id: <1234@example.com>
+Subject: Hello
+
+--b1--
+`, "\n", "\r\n")
+}
+
+func TestParse(t *testing.T) {
+	report, err := Parse(strings.NewReader(dsn("Sender@example.com", "sarah@example.net", "5.1.1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.To != "sender@example.com" || report.From != "sender@example.com" || report.MessageID != "<1234@example.com>" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(report.Recipients) != 2 {
+		t.Fatalf("expected 2 recipients, got: %d", len(report.Recipients))
+	}
+
+	bounces := report.Bounces(nil)
+	if len(bounces) != 1 {
+		t.Fatalf("expected only the failed recipient to bounce, got: %d", len(bounces))
+	}
+	b := bounces[0]
+	if b.Sender != "sender@example.com" || b.Receiver != "sarah@example.net" || b.Address != "sarah@example.net" || b.Status != "5.1.1" || !b.Hard {
+		t.Fatalf("unexpected bounce: %+v", b)
+	}
+	if b.Diagnostic != "550 5.1.1 User unknown" {
+		t.Fatalf("unexpected diagnostic: %q", b.Diagnostic)
+	}
+
+	report, err = Parse(strings.NewReader(dsn("sender@example.com", "sarah@example.net", "4.2.2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := report.Bounces(nil)[0]; b.Hard {
+		t.Fatal("expected a 4.x.x failure to be a soft bounce")
+	}
+
+	reply := "From: sarah@example.net\r\nTo: sender@example.com\r\nSubject: Out of office\r\n\r\nI am away.\r\n"
+	if _, err := Parse(strings.NewReader(reply)); !errors.Is(err, ErrNotDSN) {
+		t.Fatalf("expected an auto-reply not to be a DSN, got: %v", err)
+	}
+}
+
+func TestBouncesVERP(t *testing.T) {
+	verp, err := mailer.ParseVERP("bounces+{{hash}}@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verp.Register("sarah@example.com", "spring-sale")
+
+	// the receiver forwards to another address, which bounces
+	msg := dsn(verp.Address("sarah@example.com", "spring-sale"), "sarah@forward.example.net", "5.1.1")
+	report, err := Parse(strings.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report.From = ""
+
+	b := report.Bounces(verp)[0]
+	if b.Sender != "" || b.Receiver != "sarah@example.com" || b.Campaign != "spring-sale" || b.Address != "sarah@forward.example.net" {
+		t.Fatalf("unexpected bounce: %+v", b)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bounce
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// IMAPSource describes an IMAP mailbox from which bounces are read.
+type IMAPSource struct {
+	Addr               string
+	Username, Password string
+	// Mailbox defaults to "INBOX".
+	Mailbox   string
+	Security  mailer.Security
+	TLSConfig *tls.Config
+}
+
+func (s *IMAPSource) dial() (*client.Client, error) {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := s.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+
+	if s.Security == mailer.ImplicitTLS {
+		return client.DialTLS(s.Addr, tlsConfig)
+	}
+
+	c, err := client.Dial(s.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.Security == mailer.NoTLS {
+		return c, nil
+	}
+
+	ok, err := c.SupportStartTLS()
+	if err == nil && ok {
+		err = c.StartTLS(tlsConfig)
+	} else if err == nil && s.Security == mailer.StartTLSRequired {
+		err = errors.New("imap server does not support STARTTLS")
+	}
+	if err != nil {
+		c.Logout()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Walk calls fn with each unseen message of the mailbox. Messages for
+// which fn returns [ErrNotDSN] are left untouched, and any other error
+// stops the walk. Those for which it returns nil are only marked as
+// seen, so that they are not processed again, once commit is called,
+// which should be done after their results are saved.
+func (s *IMAPSource) Walk(fn func(msg []byte) error) (commit func() error, err error) {
+	commit = func() error { return nil }
+
+	c, err := s.login()
+	if err != nil {
+		return commit, err
+	}
+	defer c.Logout()
+
+	status, err := c.Select(s.mailbox(), false)
+	if err != nil {
+		return commit, err
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+	uids, err := c.UidSearch(criteria)
+	if err != nil || len(uids) == 0 {
+		return commit, err
+	}
+
+	all := new(imap.SeqSet)
+	all.AddNum(uids...)
+
+	section := &imap.BodySectionName{Peek: true}
+	messages := make(chan *imap.Message, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(all, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, messages)
+	}()
+
+	seen := new(imap.SeqSet)
+	var walkErr error
+	for msg := range messages {
+		if walkErr != nil {
+			continue
+		}
+
+		body := msg.GetBody(section)
+		if body == nil {
+			walkErr = fmt.Errorf("imap server returned no body for message %d", msg.Uid)
+			continue
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			walkErr = err
+			continue
+		}
+
+		switch err := fn(b); {
+		case err == nil:
+			seen.AddNum(msg.Uid)
+		case !errors.Is(err, ErrNotDSN):
+			walkErr = err
+		}
+	}
+
+	if !seen.Empty() {
+		commit = func() error { return s.markSeen(status.UidValidity, seen) }
+	}
+	return commit, errors.Join(<-done, walkErr)
+}
+
+// markSeen flags the messages with the given UIDs as seen, in a new
+// session. UIDs are only valid for as long as the mailbox keeps its
+// UIDVALIDITY, so nothing is flagged if it has changed since the walk.
+func (s *IMAPSource) markSeen(validity uint32, uids *imap.SeqSet) error {
+	c, err := s.login()
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+
+	status, err := c.Select(s.mailbox(), false)
+	if err != nil {
+		return err
+	}
+	if status.UidValidity != validity {
+		return fmt.Errorf("imap mailbox %q changed its UIDVALIDITY, processed messages are left unseen", s.mailbox())
+	}
+
+	flags := []interface{}{imap.SeenFlag}
+	return c.UidStore(uids, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil)
+}
+
+func (s *IMAPSource) login() (*client.Client, error) {
+	c, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Login(s.Username, s.Password); err != nil {
+		c.Logout()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *IMAPSource) mailbox() string {
+	if s.Mailbox == "" {
+		return "INBOX"
+	}
+	return s.Mailbox
+}
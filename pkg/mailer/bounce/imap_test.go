@@ -0,0 +1,98 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bounce
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestIMAPSource(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	c, err := client.Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	reply := "From: sarah@example.net\r\nSubject: Out of office\r\n\r\nI am away.\r\n"
+	for _, msg := range []string{dsn("a@example.com", "x@example.net", "5.1.1"), reply} {
+		if err := c.Append("INBOX", nil, time.Now(), bytes.NewBufferString(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Logout()
+
+	src := &IMAPSource{
+		Addr:     ln.Addr().String(),
+		Username: "username",
+		Password: "password",
+		Security: mailer.NoTLS,
+	}
+
+	var senders []string
+	walk := func(save bool) {
+		senders = nil
+		commit, err := src.Walk(func(msg []byte) error {
+			report, err := Parse(bytes.NewReader(msg))
+			if err != nil {
+				return err
+			}
+			senders = append(senders, report.To)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if save {
+			if err := commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	walk(false)
+	if strings.Join(senders, ",") != "a@example.com" {
+		t.Fatalf("expected a single bounce, got: %v", senders)
+	}
+
+	walk(true)
+	if strings.Join(senders, ",") != "a@example.com" {
+		t.Fatalf("expected uncommitted bounces to be walked again, got: %v", senders)
+	}
+
+	walk(true)
+	if len(senders) != 0 {
+		t.Fatalf("expected processed bounces to be marked as seen, got: %v", senders)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Walk calls fn with each message stored at path, which may be an mbox
+// file, a single message, a Maildir, or a directory of .eml files.
+// Walking stops at the first error returned by fn, other than
+// [ErrNotDSN].
+func Walk(path string, fn func(msg []byte) error) error {
+	return walk(path, func(msg []byte) error {
+		if err := fn(msg); !errors.Is(err, ErrNotDSN) {
+			return err
+		}
+		return nil
+	})
+}
+
+func walk(path string, fn func(msg []byte) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		br := bufio.NewReader(f)
+		if head, _ := br.Peek(5); string(head) == "From " {
+			return readMbox(br, fn)
+		}
+
+		b, err := io.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	}
+
+	// a Maildir keeps its messages in the "new" and "cur" subdirectories,
+	// whose files have no extension.
+	maildir := false
+	for _, sub := range []string{"new", "cur"} {
+		if info, err := os.Stat(filepath.Join(path, sub)); err == nil && info.IsDir() {
+			maildir = true
+		}
+	}
+
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if maildir && d.Name() == "tmp" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !maildir && !strings.EqualFold(filepath.Ext(p), ".eml") {
+			return nil
+		}
+
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+// readMbox calls fn with each message of an mbox, reversing the quoting
+// of "From " lines, as written by [mailer.FileTransport].
+func readMbox(r *bufio.Reader, fn func(msg []byte) error) error {
+	var msg bytes.Buffer
+	started := false
+
+	flush := func() error {
+		if !started {
+			return nil
+		}
+		// the blank line preceding the next "From " line belongs to the
+		// mbox, not to the message.
+		b := bytes.TrimSuffix(msg.Bytes(), []byte("\n"))
+		msg.Reset()
+		return fn(bytes.Clone(b))
+	}
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			switch {
+			case bytes.HasPrefix(line, []byte("From ")):
+				if err := flush(); err != nil {
+					return err
+				}
+				started = true
+			case isQuotedFrom(line):
+				msg.Write(line[1:])
+			default:
+				msg.Write(line)
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return flush()
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// isQuotedFrom reports whether line is a "From " line quoted with one or
+// more '>' characters, following the mboxrd format.
+func isQuotedFrom(line []byte) bool {
+	l := bytes.TrimLeft(line, ">")
+	return len(l) < len(line) && bytes.HasPrefix(l, []byte("From "))
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bounce
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	dir := t.TempDir()
+
+	reply := "From: sarah@example.net\nSubject: Out of office\n\nFrom here, I am away.\n"
+	mbox := "From MAILER-DAEMON Thu Jan  1 00:00:00 2024\n" + strings.ReplaceAll(dsn("a@example.com", "x@example.net", "5.1.1"), "\r\n", "\n") +
+		"\nFrom sarah@example.net Thu Jan  1 00:00:00 2024\n" + strings.Replace(reply, "\nFrom here", "\n>From here", 1) +
+		"\nFrom MAILER-DAEMON Thu Jan  1 00:00:00 2024\n" + strings.ReplaceAll(dsn("b@example.com", "y@example.net", "5.1.1"), "\r\n", "\n")
+	if err := os.WriteFile(filepath.Join(dir, "bounces.mbox"), []byte(mbox), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	maildir := filepath.Join(dir, "Maildir")
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(maildir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for file, msg := range map[string]string{
+		"new/1.host":     dsn("c@example.com", "z@example.net", "5.1.1"),
+		"cur/2.host:2,S": reply,
+		"tmp/3.host":     "partial",
+	} {
+		if err := os.WriteFile(filepath.Join(maildir, file), []byte(msg), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var senders []string
+	var messages int
+	fn := func(msg []byte) error {
+		messages++
+		report, err := Parse(strings.NewReader(string(msg)))
+		if err != nil {
+			if !strings.Contains(string(msg), "\nFrom here") {
+				t.Errorf("expected the quoted From line to be unquoted:\n%s", msg)
+			}
+			return err
+		}
+		senders = append(senders, report.To)
+		return nil
+	}
+
+	if err := Walk(filepath.Join(dir, "bounces.mbox"), fn); err != nil {
+		t.Fatal(err)
+	}
+	if messages != 3 || strings.Join(senders, ",") != "a@example.com,b@example.com" {
+		t.Fatalf("unexpected mbox messages: %d, %v", messages, senders)
+	}
+
+	messages, senders = 0, nil
+	if err := Walk(maildir, fn); err != nil {
+		t.Fatal(err)
+	}
+	if messages != 2 || strings.Join(senders, ",") != "c@example.com" {
+		t.Fatalf("unexpected maildir messages: %d, %v", messages, senders)
+	}
+}
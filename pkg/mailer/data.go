@@ -67,6 +67,21 @@ func (l *List) Data() []string {
 	return l.data
 }
 
+// Remove deletes the values of the List for which drop returns true,
+// returning the deleted values.
+func (l *List) Remove(drop func(string) bool) []string {
+	var kept, removed []string
+	for _, v := range l.data {
+		if drop(v) {
+			removed = append(removed, v)
+		} else {
+			kept = append(kept, v)
+		}
+	}
+	l.data = kept
+	return removed
+}
+
 // Variables is a convenience type that unmarshals a CSV string
 // with the format "KEY=VALUE" and converts it into a of map of
 // string keys and values, and vice versa.
@@ -116,6 +131,7 @@ func (v *Variables) MarshalCSV() (string, error) {
 				return "", err
 			}
 		}
+		i++
 	}
 
 	return builder.String(), nil
@@ -133,18 +149,53 @@ type CSVData interface{}
 
 // Sender represents an email sender with the necessary
 // information for authentication.
+//
+// The host, port, auth, security and username columns are optional,
+// and override the settings of the [SMTPTransport] for this sender,
+// allowing senders of different providers to be mixed.
 type Sender struct {
 	Email    string `csv:"email"`
 	Password string `csv:"password"`
 	Name     string `csv:"name"`
+	Host     string `csv:"host"`
+	Port     uint16 `csv:"port"`
+	Auth     string `csv:"auth"`
+	Security string `csv:"security"`
+	Username string `csv:"username"`
+}
+
+// Login returns the username with which the Sender authenticates,
+// which is its email address unless a username is given.
+func (s *Sender) Login() string {
+	if s.Username != "" {
+		return s.Username
+	}
+	return s.Email
+}
+
+// Validate checks that the optional server settings of the
+// Sender are valid.
+func (s *Sender) Validate() error {
+	if _, err := ParseAuth(s.Auth); err != nil {
+		return fmt.Errorf("sender %q: %w", s.Email, err)
+	}
+	if _, err := ParseSecurity(s.Security); err != nil {
+		return fmt.Errorf("sender %q: %w", s.Email, err)
+	}
+	return nil
 }
 
 // Receiver represents the recipient of an email message.
+//
+// The optional attachments column lists files to attach for this
+// receiver only. The paths may reference the receiver's variables,
+// e.g. "invoices/{{.name}}.pdf".
 type Receiver struct {
-	Email     string     `csv:"email"`
-	Cc        *List      `csv:"cc"`
-	Bcc       *List      `csv:"bcc"`
-	Variables *Variables `csv:"variables"`
+	Email       string     `csv:"email"`
+	Cc          *List      `csv:"cc"`
+	Bcc         *List      `csv:"bcc"`
+	Variables   *Variables `csv:"variables"`
+	Attachments *List      `csv:"attachments"`
 }
 
 // ReadFile reads CSV data and returns the unmarshalled data
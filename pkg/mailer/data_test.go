@@ -53,3 +53,36 @@ func TestReadReceiver(t *testing.T) {
     t.Fatalf("expected: %+v\ngot: %+v\n", expected, data[4])
   }
 }
+
+func TestSenderValidate(t *testing.T) {
+	valid := &Sender{Email: "a@example.com", Auth: "cram-md5", Security: "tls"}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []*Sender{
+		{Email: "a@example.com", Auth: "kerberos"},
+		{Email: "a@example.com", Security: "ssl3"},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Fatalf("expected sender to be invalid: %+v", s)
+		}
+	}
+}
+
+func TestVariablesRoundTrip(t *testing.T) {
+	v := &Variables{map[string]string{"name": "Sarah", "location": "Paris"}}
+
+	csv, err := v.MarshalCSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := &Variables{}
+	if err := got.UnmarshalCSV(csv); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, got) {
+		t.Fatalf("expected: %+v\ngot: %+v (from %q)\n", v, got, csv)
+	}
+}
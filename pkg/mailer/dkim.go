@@ -0,0 +1,150 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// Signer signs the raw bytes of an email message immediately
+// before a [Transport] sends them.
+type Signer interface {
+	// Sign returns msg, sent from the address from, with its
+	// signature applied.
+	Sign(from string, msg []byte) ([]byte, error)
+}
+
+// DKIMKey represents the DKIM private key of a sender domain, as
+// listed in a CSV file.
+type DKIMKey struct {
+	Domain   string `csv:"domain"`
+	Selector string `csv:"selector"`
+	// KeyFile is the path to a PEM encoded RSA or Ed25519 private key
+	KeyFile string `csv:"key"`
+}
+
+// dkimHeaders are the header fields covered by DKIM signatures.
+var dkimHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-Id",
+	"Mime-Version", "Content-Type", "Content-Transfer-Encoding",
+	"List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIMSigner is a [Signer] which adds a DKIM-Signature to messages sent
+// from the domains it has keys for, using relaxed/relaxed canonicalization.
+// Messages from other domains are left unsigned.
+type DKIMSigner struct {
+	options map[string]*dkim.SignOptions
+}
+
+// NewDKIMSigner constructs an instance of [mailer.DKIMSigner],
+// loading the private key of each of the given keys.
+func NewDKIMSigner(keys []*DKIMKey) (*DKIMSigner, error) {
+	s := &DKIMSigner{options: make(map[string]*dkim.SignOptions, len(keys))}
+
+	for _, k := range keys {
+		if k.Domain == "" || k.Selector == "" {
+			return nil, fmt.Errorf("dkim key %q: domain and selector are required", k.KeyFile)
+		}
+
+		signer, err := readPrivateKey(k.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("dkim key for %q: %w", k.Domain, err)
+		}
+
+		s.options[strings.ToLower(k.Domain)] = &dkim.SignOptions{
+			Domain:                 k.Domain,
+			Selector:               k.Selector,
+			Signer:                 signer,
+			HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+			BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+			HeaderKeys:             dkimHeaders,
+		}
+	}
+
+	return s, nil
+}
+
+// LoadDKIMSigner reads a CSV file of [DKIMKey] entries and constructs
+// a [mailer.DKIMSigner] from them.
+func LoadDKIMSigner(file string) (*DKIMSigner, error) {
+	keys, err := ReadFile[DKIMKey](file)
+	if err != nil {
+		return nil, err
+	}
+	return NewDKIMSigner(keys)
+}
+
+func readPrivateKey(file string) (crypto.Signer, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type: %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block: %q", block.Type)
+	}
+}
+
+// Sign implements [Signer]. The key of the sender's domain is used, or
+// failing that, the key of its closest parent domain.
+func (s *DKIMSigner) Sign(from string, msg []byte) ([]byte, error) {
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, err
+	}
+
+	_, domain, _ := strings.Cut(strings.ToLower(addr.Address), "@")
+	for domain != "" {
+		if options, ok := s.options[domain]; ok {
+			signed := new(bytes.Buffer)
+			if err := dkim.Sign(signed, bytes.NewReader(msg), options); err != nil {
+				return nil, err
+			}
+			return signed.Bytes(), nil
+		}
+		_, domain, _ = strings.Cut(domain, ".")
+	}
+
+	return msg, nil
+}
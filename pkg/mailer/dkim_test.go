@@ -0,0 +1,129 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mailer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/jordan-wright/email"
+)
+
+// writeKey writes the private key in PEM format, and returns the DNS
+// TXT record which publishes its public key.
+func writeKey(t *testing.T, file string, key any) string {
+	t.Helper()
+
+	var block *pem.Block
+	var record string
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+		pub, err := x509.MarshalPKIXPublicKey(&k.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record = "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(pub)
+	case ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		record = "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(k.Public().(ed25519.PublicKey))
+	}
+
+	if err := os.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestDKIMSigner(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := map[string]string{
+		"rsa._domainkey.example.com": writeKey(t, filepath.Join(dir, "rsa.pem"), rsaKey),
+		"ed._domainkey.example.org":  writeKey(t, filepath.Join(dir, "ed.pem"), edKey),
+	}
+
+	keys := filepath.Join(dir, "dkim.csv")
+	csv := fmt.Sprintf("domain,selector,key\nexample.com,rsa,%s\nexample.org,ed,%s\n",
+		filepath.Join(dir, "rsa.pem"), filepath.Join(dir, "ed.pem"))
+	if err := os.WriteFile(keys, []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := LoadDKIMSigner(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := &dkim.VerifyOptions{LookupTXT: func(domain string) ([]string, error) {
+		if r, ok := records[domain]; ok {
+			return []string{r}, nil
+		}
+		return nil, fmt.Errorf("no record for %q", domain)
+	}}
+
+	for _, from := range []string{"Sender <sender@example.com>", "sender@mail.example.com", "sender@example.org"} {
+		e := &email.Email{From: from, To: []string{"receiver@example.net"}, Subject: "Hello", Text: []byte("Hello,  world!  \n\n\n")}
+		msg, err := e.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signed, err := signer.Sign(from, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		verifications, err := dkim.VerifyWithOptions(bytes.NewReader(signed), lookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(verifications) != 1 || verifications[0].Err != nil {
+			t.Fatalf("%s: expected a valid signature, got: %+v", from, verifications)
+		}
+	}
+
+	msg := []byte("From: sender@example.net\r\n\r\nHello\r\n")
+	unsigned, err := signer.Sign("sender@example.net", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, unsigned) {
+		t.Fatal("expected messages from unknown domains to be left unsigned")
+	}
+}
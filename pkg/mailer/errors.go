@@ -0,0 +1,132 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// ErrorClass represents the category of an SMTP error, which
+// determines whether a failed send operation is worth retrying.
+type ErrorClass uint8
+
+const (
+	// Unknown represents an error without an SMTP reply code, such as
+	// a malformed message
+	Unknown ErrorClass = iota
+	// Transient represents a temporary (4xx) SMTP failure, or a failure
+	// of the network or of TLS
+	Transient
+	// Permanent represents a permanent (5xx) SMTP failure
+	Permanent
+)
+
+// String returns a human readable name for the ErrorClass.
+func (c ErrorClass) String() string {
+	switch c {
+	case Transient:
+		return "transient"
+	case Permanent:
+		return "permanent"
+	default:
+		return "unknown"
+	}
+}
+
+var enhancedCode = regexp.MustCompile(`^([245])\.(\d{1,3})\.(\d{1,3})\b`)
+
+// SMTPError is an error returned by an SMTP server, along with its
+// classification.
+type SMTPError struct {
+	// Code is the basic SMTP reply code, such as 550, or 0 if the
+	// error did not originate from the server
+	Code int
+	// Enhanced is the enhanced status code (RFC 3463), such as
+	// "5.1.1", if the server provided one
+	Enhanced string
+	// Msg is the text of the server's reply
+	Msg   string
+	Class ErrorClass
+	err   error
+}
+
+func (e *SMTPError) Error() string {
+	return e.err.Error()
+}
+
+func (e *SMTPError) Unwrap() error {
+	return e.err
+}
+
+// Recipient reports whether the error concerns the recipient's address
+// or mailbox, rather than the sender, the message or the server.
+func (e *SMTPError) Recipient() bool {
+	if e.Enhanced != "" {
+		// X.1.X are addressing and X.2.X are mailbox statuses
+		subject := strings.Split(e.Enhanced, ".")[1]
+		return subject == "1" || subject == "2"
+	}
+
+	switch e.Code {
+	case 450, 550, 551, 552, 553:
+		return true
+	default:
+		return false
+	}
+}
+
+// ClassifyError inspects err for an SMTP reply, and returns an [SMTPError]
+// describing it. Network and TLS errors are classified as [Transient],
+// and other errors which do not carry a reply code as [Unknown].
+func ClassifyError(err error) *SMTPError {
+	var serr *SMTPError
+	if errors.As(err, &serr) {
+		return serr
+	}
+
+	serr = &SMTPError{Class: Unknown, Msg: fmt.Sprint(err), err: err}
+
+	var terr *textproto.Error
+	if !errors.As(err, &terr) {
+		var operr *net.OpError
+		var dnserr *net.DNSError
+		var rerr tls.RecordHeaderError
+		if errors.As(err, &operr) || errors.As(err, &dnserr) || errors.As(err, &rerr) {
+			serr.Class = Transient
+		}
+		return serr
+	}
+
+	serr.Code = terr.Code
+	serr.Msg = terr.Msg
+	if m := enhancedCode.FindStringSubmatch(terr.Msg); m != nil {
+		serr.Enhanced = m[0]
+	}
+
+	switch terr.Code / 100 {
+	case 4:
+		serr.Class = Transient
+	case 5:
+		serr.Class = Permanent
+	}
+
+	return serr
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/textproto"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err       error
+		class     ErrorClass
+		enhanced  string
+		recipient bool
+	}{
+		{&textproto.Error{Code: 451, Msg: "4.7.1 Try again later"}, Transient, "4.7.1", false},
+		{&textproto.Error{Code: 452, Msg: "4.2.2 Mailbox full"}, Transient, "4.2.2", true},
+		{&textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}, Permanent, "5.1.1", true},
+		{&textproto.Error{Code: 535, Msg: "5.7.8 Authentication failed"}, Permanent, "5.7.8", false},
+		{&textproto.Error{Code: 553, Msg: "mailbox name not allowed"}, Permanent, "", true},
+		{fmt.Errorf("rcpt: %w", &textproto.Error{Code: 550, Msg: "5.1.2 Bad domain"}), Permanent, "5.1.2", true},
+		{errors.New("malformed message"), Unknown, "", false},
+		{&fs.PathError{Op: "open", Path: "missing.pdf", Err: syscall.ENOENT}, Unknown, "", false},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, Transient, "", false},
+		{fmt.Errorf("starttls: %w", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), Transient, "", false},
+	}
+
+	for _, test := range tests {
+		serr := ClassifyError(test.err)
+		if serr.Class != test.class {
+			t.Errorf("%q: expected class %s, got %s", test.err, test.class, serr.Class)
+		}
+		if serr.Enhanced != test.enhanced {
+			t.Errorf("%q: expected enhanced code %q, got %q", test.err, test.enhanced, serr.Enhanced)
+		}
+		if serr.Recipient() != test.recipient {
+			t.Errorf("%q: expected recipient %v, got %v", test.err, test.recipient, serr.Recipient())
+		}
+		if !errors.Is(serr, test.err) {
+			t.Errorf("%q: expected classified error to wrap the original", test.err)
+		}
+	}
+}
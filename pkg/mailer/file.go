@@ -0,0 +1,133 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jordan-wright/email"
+	"github.com/rs/zerolog/log"
+)
+
+// FileTransport is a [Transport] which writes email messages to disk
+// instead of sending them, either as separate .eml files or appended
+// to a single mbox file.
+type FileTransport struct {
+	// Path is the directory to which .eml files are written, or the
+	// mbox file if Mbox is set
+	Path string
+	Mbox bool
+	// Signer, if set, signs each message before it is written
+	Signer Signer
+
+	mu  sync.Mutex
+	seq uint
+}
+
+// NewFileTransport constructs an instance of [mailer.FileTransport].
+func NewFileTransport(path string, mbox bool) *FileTransport {
+	return &FileTransport{Path: path, Mbox: mbox}
+}
+
+// Send implements [Transport].
+func (t *FileTransport) Send(ctx context.Context, sender *Sender, emails []*email.Email) []Result {
+	results := make([]Result, len(emails))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, e := range emails {
+		if err := ctx.Err(); err != nil {
+			results[i].Err = fmt.Errorf("%w: %w", ErrNotSent, err)
+			continue
+		}
+
+		b, err := e.Bytes()
+		if err == nil && t.Signer != nil {
+			b, err = t.Signer.Sign(e.From, b)
+		}
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		log.Info().Str("from", sender.Email).Str("to", e.To[0]).Msg("writing email")
+		if t.Mbox {
+			from := e.Sender
+			if from == "" {
+				from = sender.Email
+			}
+			results[i].Err = t.appendMbox(from, b)
+		} else {
+			results[i].Err = t.writeEML(e.To[0], b)
+		}
+		if results[i].Err == nil {
+			results[i].Time = time.Now()
+		}
+	}
+
+	return results
+}
+
+func (t *FileTransport) writeEML(to string, b []byte) error {
+	if err := os.MkdirAll(t.Path, 0o755); err != nil {
+		return err
+	}
+
+	if addr, err := mail.ParseAddress(to); err == nil {
+		to = addr.Address
+	}
+
+	t.seq++
+	name := fmt.Sprintf("%05d-%s.eml", t.seq, strings.NewReplacer("/", "_", "\\", "_").Replace(to))
+	return os.WriteFile(filepath.Join(t.Path, name), b, 0o644)
+}
+
+func (t *FileTransport) appendMbox(from string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(t.Path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(t.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "From %s %s\n", from, time.Now().UTC().Format(time.ANSIC))
+
+	// mboxrd: quote lines beginning with any number of '>' followed by "From "
+	lines := strings.Split(strings.ReplaceAll(string(b), "\r\n", "\n"), "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			buf.WriteByte('>')
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+
+	_, err = f.Write(buf.Bytes())
+	return err
+}
@@ -15,8 +15,14 @@
 package mailer
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jordan-wright/email"
@@ -24,7 +30,8 @@ import (
 )
 
 // SendEmailsTLS sends a list of email messages, defined as
-// instances of [github.com/jordan-wright/email.Email], over SMTP.
+// instances of [github.com/jordan-wright/email.Email], over SMTP
+// on port 587, using STARTTLS if the server supports it.
 //
 // It returns the slice index of the email message that failed to be
 // sent as well as the reason for the failure.
@@ -39,30 +46,261 @@ import (
 //
 //   - auth: An instance of [mailer.Auth] (authentication mechanism such as PLAIN, LOGIN, etc,.)
 func SendEmailsTLS(sender *Sender, emails []*email.Email, host string, auth Auth) (int, error) {
-	var a smtp.Auth
-	switch auth {
-	case Plain:
-		a = smtp.PlainAuth("", sender.Email, sender.Password, host)
+	return SendEmailsTLSContext(context.Background(), sender, emails, host, auth)
+}
+
+// SendEmailsTLSContext is like [SendEmailsTLS], but stops once ctx is
+// done, in which case no further messages are sent and the index of the
+// first unsent message is returned along with the context's error.
+func SendEmailsTLSContext(ctx context.Context, sender *Sender, emails []*email.Email, host string, auth Auth) (int, error) {
+	results := make([]Result, len(emails))
+	return NewSMTPTransport(host, auth).forSender(sender).send(ctx, sender, emails, results)
+}
+
+// Security represents the way in which the connection to an
+// SMTP server is secured.
+type Security uint8
+
+const (
+	// StartTLS upgrades the connection with STARTTLS if the server
+	// supports it, and continues unencrypted otherwise
+	StartTLS Security = iota
+	// StartTLSRequired upgrades the connection with STARTTLS, failing
+	// if the server does not support it
+	StartTLSRequired
+	// ImplicitTLS connects over TLS from the start (SMTPS, usually port 465)
+	ImplicitTLS
+	// NoTLS never encrypts the connection
+	NoTLS
+)
+
+// ParseSecurity converts the name of a Security mode into a Security.
+// Valid names are "starttls", "starttls-required", "tls" and "none".
+func ParseSecurity(name string) (Security, error) {
+	switch strings.ToLower(name) {
+	case "starttls", "":
+		return StartTLS, nil
+	case "starttls-required":
+		return StartTLSRequired, nil
+	case "tls", "ssl", "smtps":
+		return ImplicitTLS, nil
+	case "none":
+		return NoTLS, nil
+	default:
+		return 0, fmt.Errorf("unknown security mode: %q", name)
+	}
+}
+
+func (t *SMTPTransport) smtpAuth(ctx context.Context, sender *Sender) (smtp.Auth, error) {
+	if sender.Password == "" {
+		return nil, nil
+	}
+
+	switch t.Auth {
 	case Login:
-		a = LoginAuth(sender.Email, sender.Password, host)
+		return LoginAuth(sender.Login(), sender.Password, t.Host), nil
 	case CRAMMD5:
-		a = smtp.CRAMMD5Auth(sender.Email, sender.Password)
+		return smtp.CRAMMD5Auth(sender.Login(), sender.Password), nil
+	case XOAuth2, OAuthBearer:
+		token, err := t.accessToken(ctx, sender)
+		if err != nil {
+			return nil, err
+		}
+		if t.Auth == XOAuth2 {
+			return XOAuth2Auth(sender.Login(), token, t.Host), nil
+		}
+		return OAuthBearerAuth(sender.Login(), token, t.Host, t.Port), nil
+	default:
+		return smtp.PlainAuth("", sender.Login(), sender.Password, t.Host), nil
+	}
+}
+
+// accessToken returns the OAuth 2.0 access token of sender. Unless the
+// transport has an [OAuth2Config], the password of the sender is used as
+// the access token, otherwise it is exchanged for one as a refresh token.
+func (t *SMTPTransport) accessToken(ctx context.Context, sender *Sender) (string, error) {
+	if t.OAuth2 == nil {
+		return sender.Password, nil
+	}
+
+	if t.tokens == nil {
+		return t.OAuth2.TokenSource(sender.Password).Token(ctx)
+	}
+
+	src, _ := t.tokens.LoadOrStore(sender.Email, t.OAuth2.TokenSource(sender.Password))
+	return src.(*RefreshTokenSource).Token(ctx)
+}
+
+// forSender returns a copy of the transport with the server
+// settings of sender applied over its own.
+func (t *SMTPTransport) forSender(sender *Sender) *SMTPTransport {
+	c := *t
+	if sender.Host != "" {
+		c.Host = sender.Host
+	}
+	if sender.Port != 0 {
+		c.Port = sender.Port
+	}
+	if sender.Auth != "" {
+		c.Auth, _ = ParseAuth(sender.Auth)
+	}
+	if sender.Security != "" {
+		c.Security, _ = ParseSecurity(sender.Security)
+	}
+	return &c
+}
+
+// dial connects to the SMTP server, secures the connection and logs in
+// as sender.
+func (t *SMTPTransport) dial(ctx context.Context, sender *Sender) (*smtp.Client, net.Conn, error) {
+	tlsConfig := &tls.Config{ServerName: t.Host}
+	if t.TLSConfig != nil {
+		tlsConfig = t.TLSConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = t.Host
+		}
+	}
+
+	addr := net.JoinHostPort(t.Host, strconv.Itoa(int(t.Port)))
+	log.Debug().Str("addr", addr).Msgf("connecting for: %s", sender.Email)
+
+	dialer := &net.Dialer{Timeout: t.Timeout}
+	var conn net.Conn
+	var err error
+	if t.Security == ImplicitTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(t.Timeout))
+	c, err := smtp.NewClient(conn, t.Host)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if t.Security == StartTLS || t.Security == StartTLSRequired {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			err = c.StartTLS(tlsConfig)
+		} else if t.Security == StartTLSRequired {
+			err = fmt.Errorf("%s does not support STARTTLS", addr)
+		}
+		if err != nil {
+			c.Close()
+			return nil, nil, err
+		}
+	}
+
+	a, err := t.smtpAuth(ctx, sender)
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	if a != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(a); err != nil {
+				c.Close()
+				return nil, nil, err
+			}
+		}
+	}
+
+	return c, conn, nil
+}
+
+func (t *SMTPTransport) send(ctx context.Context, sender *Sender, emails []*email.Email, results []Result) (int, error) {
+	if len(emails) == 0 {
+		return 0, nil
 	}
 
-	addr := fmt.Sprintf("%s:587", host)
-	log.Debug().Msgf("creating conn pool for: %s", sender.Email)
-	pool, err := email.NewPool(addr, len(emails), a)
+	c, conn, err := t.dial(ctx, sender)
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("%w: %w", ErrConnection, err)
 	}
+	defer c.Close()
+
+	for i, e := range emails {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		conn.SetDeadline(time.Now().Add(t.Timeout))
 
-	for i, email := range emails {
-		log.Info().Str("from", sender.Email).Str("to", email.To[0]).Msg("sending email")
-		err := pool.Send(email, 10*time.Second)
+		log.Info().Str("from", sender.Email).Str("to", e.To[0]).Msg("sending email")
+		code, msg, err := sendEmail(c, e, t.Signer)
 		if err != nil {
 			return i, err
 		}
+		results[i].Code, results[i].Response, results[i].Time = code, msg, time.Now()
 	}
 
+	conn.SetDeadline(time.Now().Add(t.Timeout))
+	c.Quit()
+
 	return 0, nil
 }
+
+// sendEmail performs a single mail transaction for e over c, signing
+// the message with signer if it is not nil. It returns the reply of the
+// server once the message has been accepted.
+func sendEmail(c *smtp.Client, e *email.Email, signer Signer) (int, string, error) {
+	from := e.Sender
+	if from == "" {
+		from = e.From
+	}
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return 0, "", err
+	}
+
+	msg, err := e.Bytes()
+	if err != nil {
+		return 0, "", err
+	}
+	if signer != nil {
+		if msg, err = signer.Sign(e.From, msg); err != nil {
+			return 0, "", err
+		}
+	}
+
+	if err := c.Mail(addr.Address); err != nil {
+		return 0, "", err
+	}
+
+	for _, list := range [][]string{e.To, e.Cc, e.Bcc} {
+		for _, rcpt := range list {
+			addr, err := mail.ParseAddress(rcpt)
+			if err != nil {
+				return 0, "", err
+			}
+			if err := c.Rcpt(addr.Address); err != nil {
+				return 0, "", err
+			}
+		}
+	}
+
+	// the DATA command is issued directly, rather than with c.Data, so
+	// that the reply of the server to the message can be recorded.
+	id, err := c.Text.Cmd("DATA")
+	if err != nil {
+		return 0, "", err
+	}
+	c.Text.StartResponse(id)
+	_, _, err = c.Text.ReadResponse(354)
+	c.Text.EndResponse(id)
+	if err != nil {
+		return 0, "", err
+	}
+
+	w := c.Text.DotWriter()
+	if _, err := w.Write(msg); err != nil {
+		return 0, "", err
+	}
+	if err := w.Close(); err != nil {
+		return 0, "", err
+	}
+	return c.Text.ReadResponse(250)
+}
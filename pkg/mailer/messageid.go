@@ -0,0 +1,45 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewMessageID returns a unique Message-ID, including the angle
+// brackets, in the domain of the address from.
+func NewMessageID(from string) (string, error) {
+	if a, err := mail.ParseAddress(from); err == nil {
+		from = a.Address
+	}
+	_, domain, ok := strings.Cut(from, "@")
+	if !ok || domain == "" {
+		return "", fmt.Errorf("address %q has no domain", from)
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	now := strconv.FormatInt(time.Now().UnixNano(), 36)
+	return fmt.Sprintf("<%s.%s@%s>", now, hex.EncodeToString(b), domain), nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mailer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewMessageID(t *testing.T) {
+	a, err := NewMessageID("Sender <sender@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewMessageID("sender@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(a, "<") || !strings.HasSuffix(a, "@example.com>") {
+		t.Fatalf("unexpected message id: %s", a)
+	}
+	if a == b {
+		t.Fatalf("expected unique message ids, got: %s twice", a)
+	}
+
+	if _, err := NewMessageID("sender"); err == nil {
+		t.Fatal("expected an address without a domain to fail")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mailer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func tokenServer(t *testing.T, requests *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.PostFormValue("grant_type") != "refresh_token" || r.PostFormValue("client_id") != "client" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_request"}`))
+			return
+		}
+		if r.PostFormValue("refresh_token") != "refresh" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant","error_description":"Bad refresh token"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"access","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestRefreshTokenSource(t *testing.T) {
+	requests := new(atomic.Int32)
+	srv := tokenServer(t, requests)
+	config := &OAuth2Config{TokenURL: srv.URL, ClientID: "client"}
+
+	src := config.TokenSource("refresh")
+	for i := 0; i < 2; i++ {
+		token, err := src.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "access" {
+			t.Fatalf("expected token %q, got: %q", "access", token)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("expected the token to be cached, got %d requests", n)
+	}
+
+	if _, err := config.TokenSource("expired").Token(context.Background()); err == nil {
+		t.Fatal("expected an invalid refresh token to fail")
+	}
+}
+
+func TestSMTPTransportOAuth(t *testing.T) {
+	requests := new(atomic.Int32)
+	tokens := tokenServer(t, requests)
+
+	for _, auth := range []Auth{XOAuth2, OAuthBearer} {
+		srv, port, tlsConfig := startSink(t, true, false)
+
+		transport := NewSMTPTransport("localhost", auth)
+		transport.Port = port
+		transport.TLSConfig = tlsConfig
+		transport.OAuth2 = &OAuth2Config{TokenURL: tokens.URL, ClientID: "client"}
+
+		sender := &Sender{Email: "sender@example.com", Password: "refresh"}
+		for _, r := range transport.Send(context.Background(), sender, testEmails()) {
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+		}
+
+		if summary := srv.Summary(); summary.Messages != 2 {
+			t.Fatalf("unexpected summary: %+v", summary)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/jordan-wright/email"
+)
+
+// attachment represents a file attached to email messages.
+type attachment struct {
+	name, contentType string
+	content           []byte
+}
+
+// readAttachment reads the file at path, detecting its MIME type from
+// its extension, or failing that, from its content.
+func readAttachment(path string) (*attachment, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = http.DetectContentType(b)
+	}
+
+	return &attachment{name: filepath.Base(path), contentType: contentType, content: b}, nil
+}
+
+// expandAttachment executes path as a template with the variables
+// of a receiver, allowing per-receiver file names such as
+// "invoices/{{.name}}.pdf".
+func expandAttachment(path string, data map[string]string) (string, error) {
+	if !strings.Contains(path, "{{") {
+		return path, nil
+	}
+
+	t, err := template.New("attachment").Option("missingkey=error").Funcs(funcs).Parse(path)
+	if err != nil {
+		return "", err
+	}
+
+	b := new(strings.Builder)
+	if err := t.Execute(b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// attach adds the campaign-wide attachments of task, and those of the
+// receiver, to e. It fails if the total size of the attachments of e,
+// including any embedded images, exceeds the limit of task, which is
+// checked before the files of the receiver are read.
+func attach(e *email.Email, task *task, paths []string, data map[string]string) error {
+	var size int64
+	for _, a := range e.Attachments {
+		size += int64(len(a.Content))
+	}
+	for _, a := range task.attachments {
+		size += int64(len(a.content))
+	}
+
+	files := make([]string, 0, len(paths))
+	for _, p := range paths {
+		path, err := expandAttachment(p, data)
+		if err != nil {
+			return err
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		files = append(files, path)
+	}
+	if task.maxAttachmentSize > 0 && size > task.maxAttachmentSize {
+		return fmt.Errorf("attachments for %q are %d bytes, exceeding the limit of %d bytes", e.To[0], size, task.maxAttachmentSize)
+	}
+
+	for _, a := range task.attachments {
+		if _, err := e.Attach(bytes.NewReader(a.content), a.name, a.contentType); err != nil {
+			return err
+		}
+	}
+	for _, path := range files {
+		a, err := readAttachment(path)
+		if err != nil {
+			return err
+		}
+		if _, err := e.Attach(bytes.NewReader(a.content), a.name, a.contentType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
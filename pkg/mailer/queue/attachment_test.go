@@ -0,0 +1,117 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+)
+
+func TestAttachments(t *testing.T) {
+	dir := t.TempDir()
+	brochure := filepath.Join(dir, "brochure.pdf")
+	if err := os.WriteFile(brochure, []byte("%PDF-1.4 brochure"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Sarah.dat"), []byte("<html><body>invoice</body></html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	q := defaultQueue()
+	if err := WithAttachments(brochure)(q); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver := &mailer.Receiver{Email: "sarah@example.com", Variables: &mailer.Variables{}, Attachments: &mailer.List{}}
+	if err := receiver.Variables.UnmarshalCSV("name=Sarah"); err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.Attachments.UnmarshalCSV(filepath.Join(dir, "{{.name}}.dat")); err != nil {
+		t.Fatal(err)
+	}
+
+	task := &task{
+		sender:            &mailer.Sender{Email: "sender@example.com"},
+		receivers:         []*mailer.Receiver{receiver},
+		text:              template.Must(template.New("text").Parse("Hello {{.name}}")),
+		attachments:       q.attachments,
+		maxAttachmentSize: q.maxAttachmentSize,
+	}
+
+	emails, errs := createEmails(task, "sender@example.com")
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+
+	attachments := emails[0].Attachments
+	if len(attachments) != 2 {
+		t.Fatalf("expected 2 attachments, got: %d", len(attachments))
+	}
+	if a := attachments[0]; a.Filename != "brochure.pdf" || a.ContentType != "application/pdf" {
+		t.Fatalf("unexpected campaign attachment: %s (%s)", a.Filename, a.ContentType)
+	}
+	if a := attachments[1]; a.Filename != "Sarah.dat" || a.ContentType != "text/html; charset=utf-8" {
+		t.Fatalf("unexpected receiver attachment: %s (%s)", a.Filename, a.ContentType)
+	}
+
+	task.maxAttachmentSize = 24
+	if _, errs := createEmails(task, "sender@example.com"); errs[0] == nil {
+		t.Fatal("expected attachments exceeding the size limit to fail")
+	}
+	if err := q.preflight(); err != nil {
+		t.Fatalf("expected the campaign attachment to fit the default limit, got: %v", err)
+	}
+
+	// the campaign attachment alone exceeds the limit, so the run fails
+	// before anything is sent
+	q.maxAttachmentSize = 16
+	if err := q.preflight(); err == nil {
+		t.Fatal("expected campaign attachments exceeding the size limit to fail the preflight check")
+	}
+}
+
+func TestRunWithMissingAttachment(t *testing.T) {
+	chdirTemp(t)
+
+	transport := &fakeTransport{}
+	q := newTestQueue(t, 5, WithTransport(transport), WithRateMinute(5))
+	q.receivers[2].Attachments = &mailer.List{}
+	if err := q.receivers[2].Attachments.UnmarshalCSV("missing.pdf"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the receiver with the missing attachment fails
+	if len(transport.sent) != 4 {
+		t.Fatalf("expected 4 emails to be sent, got: %d", len(transport.sent))
+	}
+	if len(q.failed) != 1 || q.failed[0].Email != "2@example.com" || q.failed[0].Attempts != 1 {
+		t.Fatalf("expected a single failed receiver, got: %+v", q.failed)
+	}
+	if q.errorCount != 0 {
+		t.Fatalf("expected the failure not to count against the sender, got: %d", q.errorCount)
+	}
+	if n := q.limiters["sender@example.com"].available(q.clock.Now()); n != 1 {
+		t.Fatalf("expected the unsent email not to count against the limits, got: %d available", n)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// clock provides a Queue with the current time, and pauses it while
+// it waits for senders to become available.
+type clock interface {
+	Now() time.Time
+	// Sleep pauses the current goroutine for at least the duration d,
+	// returning early if ctx is done.
+	Sleep(ctx context.Context, d time.Duration)
+}
+
+// realClock is a clock backed by the system time.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// virtualClock is a clock which advances instantly when slept on, used
+// to simulate the schedule of a Queue without waiting for it.
+type virtualClock struct {
+	now time.Time
+}
+
+func (c *virtualClock) Now() time.Time {
+	return c.now
+}
+
+func (c *virtualClock) Sleep(ctx context.Context, d time.Duration) {
+	if d > 0 && ctx.Err() == nil {
+		c.now = c.now.Add(d)
+	}
+}
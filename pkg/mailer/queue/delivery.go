@@ -0,0 +1,140 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// deferred marks a delivery log entry for an email which failed
+	// transiently and is to be retried
+	deferred = "deferred"
+)
+
+// DeliveryEntry represents a single line of the JSONL delivery log, which
+// records every attempt to send an email.
+type DeliveryEntry struct {
+	Campaign  string     `json:"campaign,omitempty"`
+	Sender    string     `json:"sender"`
+	Receiver  string     `json:"receiver"`
+	MessageID string     `json:"message_id,omitempty"`
+	Status    string     `json:"status"`
+	Attempt   uint8      `json:"attempt"`
+	Created   time.Time  `json:"created"`
+	Sent      *time.Time `json:"sent,omitempty"`
+	Code      int        `json:"code,omitempty"`
+	Response  string     `json:"response,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// deliveryLog is an append-only JSONL file to which a [DeliveryEntry]
+// is written for every attempt to send an email.
+type deliveryLog struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+// openDeliveryLog opens the delivery log for appending. The entries of
+// previous runs are kept, so that late bounces of earlier campaigns can
+// still be attributed, unless truncate is set.
+func openDeliveryLog(filename string, truncate bool) (*deliveryLog, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if truncate {
+		flags |= os.O_TRUNC
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filename, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := json.NewEncoder(file)
+	enc.SetEscapeHTML(false)
+
+	log.Debug().Str("file", filename).Msg("opened delivery log")
+	return &deliveryLog{file: file, enc: enc}, nil
+}
+
+func (l *deliveryLog) write(entry *DeliveryEntry) error {
+	if l == nil {
+		return nil
+	}
+	return l.enc.Encode(entry)
+}
+
+func (l *deliveryLog) close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// ReadDeliveryLog reads the entries of a delivery log written by a run.
+func ReadDeliveryLog(filename string) ([]*DeliveryEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []*DeliveryEntry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		entry := new(DeliveryEntry)
+		if err := json.Unmarshal(s.Bytes(), entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, s.Err()
+}
+
+// logDelivery writes the entry of the email sent to receiver by a worker
+// with the given status. It must be called before the attempt is counted
+// by [Queue.retry].
+func (q *Queue) logDelivery(res *workerResult, receiver *mailer.Receiver, status string, err error) error {
+	if q.deliveries == nil {
+		return nil
+	}
+
+	entry, ok := res.deliveries[receiver]
+	if !ok {
+		entry = &DeliveryEntry{Campaign: q.campaign, Sender: res.sender, Receiver: receiver.Email, Created: q.clock.Now()}
+	}
+	entry.Status = status
+	entry.Attempt = q.attempts[receiver] + 1
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	return q.deliveries.write(entry)
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jordan-wright/email"
+)
+
+// srcAttr matches the src attributes of HTML elements, capturing the
+// quoted path.
+var srcAttr = regexp.MustCompile(`(?i)(\ssrc\s*=\s*)(?:"([^"]*)"|'([^']*)')`)
+
+// isLocal reports whether src refers to a local file, rather than a URL
+// such as "https://...", "cid:..." or "data:...".
+func isLocal(src string) bool {
+	if src == "" || strings.HasPrefix(src, "//") || strings.HasPrefix(src, "#") {
+		return false
+	}
+	u, err := url.Parse(src)
+	return err == nil && (u.Scheme == "" || len(u.Scheme) == 1) // allow windows drive letters
+}
+
+// embedImages attaches the local files referenced by the src attributes
+// of html to e as inline parts, and rewrites the references to their
+// "cid:" URLs. Paths are resolved against dir, and must not be absolute
+// or leave it, as they may come from the variables of a receiver.
+func embedImages(e *email.Email, html []byte, dir string) ([]byte, error) {
+	cids := make(map[string]string)
+	var embedErr error
+
+	out := srcAttr.ReplaceAllFunc(html, func(m []byte) []byte {
+		groups := srcAttr.FindSubmatch(m)
+		src := string(groups[2])
+		quote := `"`
+		if len(groups[3]) > 0 {
+			src, quote = string(groups[3]), `'`
+		}
+
+		if embedErr != nil || !isLocal(src) {
+			return m
+		}
+
+		path := src
+		if p, err := url.PathUnescape(src); err == nil {
+			path = p
+		}
+		if !filepath.IsLocal(path) {
+			embedErr = fmt.Errorf("embedding image: %q is outside of the html directory", src)
+			return m
+		}
+		path = filepath.Join(dir, path)
+
+		cid, ok := cids[path]
+		if !ok {
+			a, err := readAttachment(path)
+			if err != nil {
+				embedErr = fmt.Errorf("embedding image: %w", err)
+				return m
+			}
+
+			// the name of the file may contain characters which are
+			// not allowed in a Content-ID, so it is left out
+			cid = fmt.Sprintf("img%d@hermes", len(cids)+1)
+			at, err := e.Attach(bytes.NewReader(a.content), a.name, a.contentType)
+			if err != nil {
+				embedErr = err
+				return m
+			}
+			at.HTMLRelated = true
+			at.Header.Set("Content-ID", "<"+cid+">")
+			cids[path] = cid
+		}
+
+		return []byte(string(groups[1]) + quote + "cid:" + cid + quote)
+	})
+
+	if embedErr != nil {
+		return nil, embedErr
+	}
+	return out, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jordan-wright/email"
+)
+
+func TestEmbedImages(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "img"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "img", "logo.png"), []byte("\x89PNG\r\n\x1a\nlogo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	html := `<img src="img/logo.png"><img src='img/logo.png'>` +
+		`<img src="https://example.com/remote.png"><img src="cid:existing"><img src="data:image/png;base64,AA==">`
+
+	e := email.NewEmail()
+	out, err := embedImages(e, []byte(html), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Attachments) != 1 {
+		t.Fatalf("expected 1 embedded image, got: %d", len(e.Attachments))
+	}
+	a := e.Attachments[0]
+	if !a.HTMLRelated || a.Filename != "logo.png" || a.ContentType != "image/png" {
+		t.Fatalf("unexpected embedded image: %s (%s), related: %t", a.Filename, a.ContentType, a.HTMLRelated)
+	}
+
+	cid := strings.Trim(a.Header.Get("Content-ID"), "<>")
+	want := `<img src="cid:` + cid + `"><img src='cid:` + cid + `'>` +
+		`<img src="https://example.com/remote.png"><img src="cid:existing"><img src="data:image/png;base64,AA==">`
+	if string(out) != want {
+		t.Fatalf("unexpected html:\n%s\nwant:\n%s", out, want)
+	}
+
+	e.HTML = out
+	b, err := e.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "multipart/related") || !strings.Contains(string(b), "Content-Disposition: inline") {
+		t.Fatalf("expected an inline multipart/related part:\n%s", b)
+	}
+
+	if _, err := embedImages(email.NewEmail(), []byte(`<img src="missing.png">`), dir); err == nil {
+		t.Fatal("expected a missing image to fail")
+	}
+
+	// a variable of a receiver must not be able to embed any other file
+	outside := filepath.Join(filepath.Dir(dir), "secret.png")
+	if err := os.WriteFile(outside, []byte("\x89PNG\r\n\x1a\nsecret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{outside, "../" + filepath.Base(outside), "img/../../secret.png"} {
+		if _, err := embedImages(email.NewEmail(), []byte(`<img src="`+src+`">`), dir); err == nil {
+			t.Fatalf("expected %q to be rejected", src)
+		}
+	}
+}
+
+func TestEmbedImagesFilenames(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "my <logo>.png"), []byte("\x89PNG\r\n\x1a\nlogo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := email.NewEmail()
+	out, err := embedImages(e, []byte(`<img src="my%20%3Clogo%3E.png"><img src="my <logo>.png">`), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Attachments) != 1 {
+		t.Fatalf("expected 1 embedded image, got: %d", len(e.Attachments))
+	}
+	if cid := e.Attachments[0].Header.Get("Content-ID"); cid != "<img1@hermes>" {
+		t.Fatalf("unexpected Content-ID: %s", cid)
+	}
+	if want := `<img src="cid:img1@hermes"><img src="cid:img1@hermes">`; string(out) != want {
+		t.Fatalf("unexpected html:\n%s\nwant:\n%s", out, want)
+	}
+}
@@ -15,9 +15,20 @@
 package queue
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"text/template"
 	"time"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/suppress"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/unsubscribe"
+	"github.com/gocarina/gocsv"
 )
 
 // OptFunc represents a function type for configuring a Queue.
@@ -44,63 +55,479 @@ func WithReadReceipts(e string) OptFunc {
 	}
 }
 
+// htmlFuncs are the functions available to HTML templates, in addition
+// to [funcs] and the builtin ones of [htmltemplate].
+var htmlFuncs = htmltemplate.FuncMap{
+	// raw inserts a trusted fragment of HTML as is, where values are
+	// otherwise escaped according to their context.
+	"raw": func(s string) htmltemplate.HTML { return htmltemplate.HTML(s) },
+}
+
 // WithHTML sets the HTML content for the emails to be sent
-// by the Queue.
+// by the Queue. The content is rendered with [htmltemplate], escaping
+// the variables of each receiver, unless they are passed through the
+// "raw" function, e.g. {{raw .signature}}.
 func WithHTML(file string) OptFunc {
 	return func(q *Queue) error {
+		if file == "" {
+			return nil
+		}
+
 		b, err := os.ReadFile(file)
 		if err != nil {
+			return err
+		}
+
+		t, err := htmltemplate.New("html").
+			Option("missingkey=error").
+			Funcs(htmltemplate.FuncMap(funcs)).
+			Funcs(htmlFuncs).
+			Parse(string(b))
+		if err != nil {
+			return err
+		}
+		q.html = t
+		q.htmlDir = filepath.Dir(file)
+
+		return nil
+	}
+}
+
+// WithPreheader sets the preheader of the emails to be sent by the
+// Queue, which is the text shown after the subject by email clients.
+// Like the subject, it is a template executed with the variables of each
+// receiver, and it is inserted into the HTML content as hidden text.
+func WithPreheader(text string) OptFunc {
+	return func(q *Queue) error {
+		if text == "" {
 			return nil
 		}
 
-		t, err := template.New("html").Parse(string(b))
+		t, err := template.New("preheader").Option("missingkey=error").Funcs(funcs).Parse(text)
 		if err != nil {
+			return err
+		}
+		q.preheader = t
+
+		return nil
+	}
+}
+
+// WithTemplateDir sets a directory of layouts and partials shared by the
+// content of the Queue. Its ".txt" and ".tmpl" files are available to
+// the text content, and its ".html" files to the HTML content, such that
+// the content may include {{template "footer" .}}, or extend a layout by
+// defining its blocks and including it.
+func WithTemplateDir(dir string) OptFunc {
+	return func(q *Queue) error {
+		if dir == "" {
 			return nil
 		}
-		q.html = t
 
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("template dir is not a directory: %q", dir)
+		}
+		q.templateDir = dir
+
+		return nil
+	}
+}
+
+// WithAttachments attaches the given files to every email sent
+// by the Queue.
+func WithAttachments(files ...string) OptFunc {
+	return func(q *Queue) error {
+		for _, file := range files {
+			a, err := readAttachment(file)
+			if err != nil {
+				return err
+			}
+			q.attachments = append(q.attachments, a)
+		}
+		return nil
+	}
+}
+
+// WithMaxAttachmentSize sets the maximum total size, in bytes, of the
+// attachments of a single email. Emails exceeding it are not sent.
+func WithMaxAttachmentSize(size int64) OptFunc {
+	return func(q *Queue) error {
+		if size > 0 {
+			q.maxAttachmentSize = size
+		}
+		return nil
+	}
+}
+
+// WithEmbeddedImages attaches the local images referenced by the HTML
+// content of each email as inline parts, rewriting the references to
+// "cid:" URLs so that they display without remote hosting. Paths are
+// resolved against the directory of the HTML file, and must not leave it.
+func WithEmbeddedImages(embed bool) OptFunc {
+	return func(q *Queue) error {
+		q.embedImages = embed
+		return nil
+	}
+}
+
+// WithRateSecond sets the maximum number of emails that can be sent by
+// a single sender in a second, 0 meaning unlimited.
+func WithRateSecond(rate uint16) OptFunc {
+	return func(q *Queue) error {
+		q.limits.PerSecond = uint(rate)
 		return nil
 	}
 }
 
 // WithRateMinute sets the maximum number of emails that can be sent by
-// a single sender in a minute.
+// a single sender in a minute, 0 meaning unlimited.
 func WithRateMinute(rate uint16) OptFunc {
 	return func(q *Queue) error {
-		if rate <= 0 {
-			return nil
-		}
-		q.perMinute = rate
+		q.limits.PerMinute = uint(rate)
+		return nil
+	}
+}
+
+// WithRateHourly sets the maximum number of emails that can be sent by
+// a single sender in an hour, 0 meaning unlimited.
+func WithRateHourly(rate uint16) OptFunc {
+	return func(q *Queue) error {
+		q.limits.PerHour = uint(rate)
 		return nil
 	}
 }
 
 // WithRateDaily sets the maximum number of emails that can be sent by
-// a single sender in a day.
+// a single sender in a day, 0 meaning unlimited.
 func WithRateDaily(rate uint16) OptFunc {
 	return func(q *Queue) error {
-		if rate <= 0 {
-			q.perDay = 100
+		q.limits.PerDay = uint(rate)
+		return nil
+	}
+}
+
+// WithDailyReset sets how the daily rate of each sender is counted. By
+// default it is counted per calendar day in loc (the local timezone if
+// nil), resetting at midnight. If rolling is set, it is instead counted
+// over the last 24 hours.
+func WithDailyReset(rolling bool, loc *time.Location) OptFunc {
+	return func(q *Queue) error {
+		q.limits.Rolling = rolling
+		if loc != nil {
+			q.limits.Location = loc
+		}
+		return nil
+	}
+}
+
+// WithBatchSize sets the maximum number of emails sent by a sender over
+// a single connection. Batches are further limited by the rates of the
+// sender, but do not affect them.
+func WithBatchSize(size uint16) OptFunc {
+	return func(q *Queue) error {
+		if size <= 0 {
 			return nil
 		}
-		q.perDay = rate
+		q.batchSize = uint(size)
+		return nil
+	}
+}
+
+// WithTransport sets the [mailer.Transport] through which the Queue
+// sends emails. By default, emails are sent over SMTP to the host
+// given to [New].
+func WithTransport(t mailer.Transport) OptFunc {
+	return func(q *Queue) error {
+		q.transport = t
+		return nil
+	}
+}
+
+// WithPort sets the port of the SMTP host used by the default transport.
+func WithPort(port uint16) OptFunc {
+	return func(q *Queue) error {
+		if port > 0 {
+			q.port = port
+		}
+		return nil
+	}
+}
+
+// WithSecurity sets the way in which the default transport secures its
+// connection to the SMTP host.
+func WithSecurity(s mailer.Security) OptFunc {
+	return func(q *Queue) error {
+		q.security = s
+		return nil
+	}
+}
+
+// WithAuth sets the authentication mechanism used by the default
+// transport to log in as each sender.
+func WithAuth(a mailer.Auth) OptFunc {
+	return func(q *Queue) error {
+		q.auth = a
+		return nil
+	}
+}
+
+// WithOAuth2 sets the OAuth 2.0 token endpoint at which the default
+// transport exchanges the passwords of senders, used as refresh tokens,
+// for access tokens when authenticating with XOAUTH2 or OAUTHBEARER.
+func WithOAuth2(c *mailer.OAuth2Config) OptFunc {
+	return func(q *Queue) error {
+		q.oauth2 = c
+		return nil
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used by the default SMTP
+// transport, such as additional trusted root certificates.
+func WithTLSConfig(c *tls.Config) OptFunc {
+	return func(q *Queue) error {
+		q.tlsConfig = c
+		return nil
+	}
+}
+
+// WithDryRun simulates a run of the Queue without sending any emails.
+// The schedule of the run is simulated without waiting for senders to
+// become available, and the resulting plan, along with the results of
+// the run, is saved to dir. Unless a transport has been set with
+// [WithTransport], the emails are written to dir as .eml files, or to
+// a single "messages.mbox" file if mbox is set. A dry run writes no
+// journal, and cannot be combined with [WithResume].
+func WithDryRun(dir string, mbox bool) OptFunc {
+	return func(q *Queue) error {
+		q.dryRun = true
+		q.mbox = mbox
+		q.dir = dir
+		q.clock = &virtualClock{now: time.Now()}
 		return nil
 	}
 }
 
+// WithDKIM signs the emails sent by the default transport with the DKIM
+// keys listed in file, a CSV file with the columns: domain, selector and
+// key (the path to the private key).
+func WithDKIM(file string) OptFunc {
+	return func(q *Queue) error {
+		if file == "" {
+			return nil
+		}
+
+		signer, err := mailer.LoadDKIMSigner(file)
+		if err != nil {
+			return err
+		}
+		q.signer = signer
+		return nil
+	}
+}
+
+// WithRetries sets the maximum number of attempts made to send an email
+// which has failed with a transient error, and the delay before the first
+// retry. The delay doubles with each subsequent attempt.
+func WithRetries(attempts uint8, delay time.Duration) OptFunc {
+	return func(q *Queue) error {
+		if attempts > 0 {
+			q.maxAttempts = attempts
+		}
+		if delay > 0 {
+			q.retryDelay = delay
+		}
+		return nil
+	}
+}
+
+// WithJournal sets the file to which the outcome of every send
+// operation is appended, allowing an interrupted run to be resumed.
+func WithJournal(file string) OptFunc {
+	return func(q *Queue) error {
+		q.journalFile = file
+		return nil
+	}
+}
+
+// WithDeliveryLog sets the JSONL file to which an entry is written for
+// every attempt to send an email, recording its Message-ID and the reply
+// of the server. Entries are appended across runs, so that bounces can be
+// attributed to any campaign sent with the same log. In a dry run, the
+// log is written to the output directory instead.
+func WithDeliveryLog(file string) OptFunc {
+	return func(q *Queue) error {
+		q.deliveryFile = file
+		return nil
+	}
+}
+
+// WithResume resumes a previous run from its journal. Receivers which
+// have already been delivered to are skipped, and new entries are
+// appended to the same journal.
+func WithResume(file string) OptFunc {
+	return func(q *Queue) error {
+		if file == "" {
+			return nil
+		}
+
+		entries, err := mailer.ReadFile[JournalEntry](file)
+		if err != nil {
+			return err
+		}
+		q.resume(entries)
+		q.journalFile = file
+		q.resumed = true
+
+		return nil
+	}
+}
+
+// WithQuotaLog sets the file in which the sends of every run are kept, so
+// that they count against the daily quotas of later runs on the same day.
+// Sends older than two days are dropped from it.
+func WithQuotaLog(file string) OptFunc {
+	return func(q *Queue) error {
+		if file == "" {
+			return nil
+		}
+
+		entries, err := mailer.ReadFile[JournalEntry](file)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, gocsv.ErrEmptyCSVFile) {
+			return err
+		}
+
+		now := q.clock.Now()
+		q.quotaFile = file
+		q.sent = nil
+		for _, e := range entries {
+			if e.Status == delivered && now.Sub(e.Time) < historyWindow {
+				q.sent = append(q.sent, e)
+			}
+		}
+
+		return nil
+	}
+}
+
+// WithSuppressionList drops the receivers, and Cc and Bcc addresses,
+// which are in the suppression list stored in file. Dropped receivers
+// are saved to "suppressed_receivers.csv" at the end of the run.
+func WithSuppressionList(file string) OptFunc {
+	return func(q *Queue) error {
+		if file == "" {
+			return nil
+		}
+
+		list, err := suppress.Open(file)
+		if err != nil {
+			return err
+		}
+		q.suppress(list)
+
+		return nil
+	}
+}
+
+// WithUnsubscribe adds List-Unsubscribe and List-Unsubscribe-Post
+// headers with links signed for each receiver, and exposes the link to
+// the templates as "unsubscribe_url".
+func WithUnsubscribe(config *unsubscribe.Config) OptFunc {
+	return func(q *Queue) error {
+		if config == nil {
+			return nil
+		}
+		if config.BaseURL == "" && config.Mailto == "" {
+			return errors.New("unsubscribe requires a url or a mailto address")
+		}
+		if len(config.Secret) == 0 {
+			return errors.New("unsubscribe requires a secret with which links are signed")
+		}
+
+		q.unsubscribe = config
+		return nil
+	}
+}
+
+// WithCampaign sets the name of the campaign, which is hashed into the
+// envelope senders built by [WithVERP].
+func WithCampaign(name string) OptFunc {
+	return func(q *Queue) error {
+		q.campaign = name
+		return nil
+	}
+}
+
+// WithVERP sends every email with its own envelope sender, built from a
+// template such as "bounces+{{hash}}@example.com", so that bounces can be
+// attributed to the receiver and campaign of the email, as resolved from
+// the delivery log. The From header is left unchanged.
+func WithVERP(template string) OptFunc {
+	return func(q *Queue) error {
+		if template == "" {
+			return nil
+		}
+
+		v, err := mailer.ParseVERP(template)
+		if err != nil {
+			return err
+		}
+		q.verp = v
+
+		return nil
+	}
+}
+
+// mboxFile is the file in the dry run directory to which emails are
+// written if they are written to an mbox file.
+const mboxFile = "messages.mbox"
+
+func (q *Queue) defaultTransport() mailer.Transport {
+	if q.dryRun {
+		path := q.dir
+		if q.mbox {
+			path = filepath.Join(q.dir, mboxFile)
+		}
+
+		t := mailer.NewFileTransport(path, q.mbox)
+		t.Signer = q.signer
+		return t
+	}
+
+	t := mailer.NewSMTPTransport(q.host, q.auth)
+	t.Port = q.port
+	t.Security = q.security
+	t.TLSConfig = q.tlsConfig
+	t.OAuth2 = q.oauth2
+	t.Signer = q.signer
+	return t
+}
+
 func defaultQueue() *Queue {
 	return &Queue{
-		perDay:         100,
-		perMinute:      2,
-		workers:        2,
-		start:          time.Now(),
-		status:         make(map[string]*Stats),
-		errorThreshold: 6,
-		errorCount:     0,
+		limits:            Limits{PerMinute: 2, PerDay: 100, Location: time.Local},
+		batchSize:         10,
+		workers:           2,
+		port:              587,
+		maxAttachmentSize: 10 << 20,
+		clock:             realClock{},
+		status:            make(map[string]*Stats),
+		attempts:          make(map[*mailer.Receiver]uint8),
+		messageIDs:        make(map[*mailer.Receiver]string),
+		maxAttempts:       5,
+		retryDelay:        1 * time.Minute,
+		errorThreshold:    6,
+		errorCount:        0,
 	}
 }
 
 // New constructs an instance of [queue.Queue] with the provided options.
+// The subject is a template which, like the text content, is executed
+// with the variables of each receiver. The senders may be omitted for a
+// Queue which is only checked with [Queue.Validate].
 func New(senders, receivers, subject, host, textFile string, opts ...OptFunc) (*Queue, error) {
 	q := defaultQueue()
 
@@ -108,19 +535,24 @@ func New(senders, receivers, subject, host, textFile string, opts ...OptFunc) (*
 		return nil, err
 	}
 
-	q.subject = subject
 	q.host = host
 
 	for _, sender := range q.senders {
 		q.status[sender.Email] = &Stats{Sender: sender.Email}
 	}
 
+	s, err := template.New("subject").Option("missingkey=error").Funcs(funcs).Parse(subject)
+	if err != nil {
+		return nil, err
+	}
+	q.subject = s
+
 	b, err := os.ReadFile(textFile)
 	if err != nil {
 		return nil, err
 	}
 
-	t, err := template.New("text").Parse(string(b))
+	t, err := template.New("text").Option("missingkey=error").Funcs(funcs).Parse(string(b))
 	if err != nil {
 		return nil, err
 	}
@@ -132,5 +564,22 @@ func New(senders, receivers, subject, host, textFile string, opts ...OptFunc) (*
 		}
 	}
 
+	// a dry run writes no journal, whatever the order of the options, so
+	// there is nothing to resume it from
+	if q.dryRun {
+		if q.resumed {
+			return nil, errors.New("a dry run cannot be resumed")
+		}
+		q.journalFile = ""
+	}
+
+	if err := q.loadTemplateDir(); err != nil {
+		return nil, err
+	}
+
+	if q.transport == nil {
+		q.transport = q.defaultTransport()
+	}
+
 	return q, nil
 }
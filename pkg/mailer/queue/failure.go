@@ -0,0 +1,47 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import "github.com/abh1sheke/hermes-mailer/pkg/mailer"
+
+// FailedReceiver represents a receiver which could not be sent to, along
+// with the reason. Its leading columns are those of [mailer.Receiver], so
+// that a file of failed receivers can be used as the receivers of a
+// retry run, in which the other columns are ignored.
+type FailedReceiver struct {
+	mailer.Receiver
+	Sender   string `csv:"sender"`
+	Code     int    `csv:"code"`
+	Error    string `csv:"error"`
+	Attempts uint8  `csv:"attempts"`
+}
+
+// fail records that sending to receiver from sender failed for good with
+// err, after the given number of attempts.
+func (q *Queue) fail(receiver *mailer.Receiver, sender string, err error, attempts uint8) {
+	serr := mailer.ClassifyError(err)
+	msg := serr.Msg
+	if msg == "" {
+		msg = err.Error()
+	}
+
+	q.failed = append(q.failed, &FailedReceiver{
+		Receiver: *receiver,
+		Sender:   sender,
+		Code:     serr.Code,
+		Error:    msg,
+		Attempts: attempts,
+	})
+}
@@ -0,0 +1,258 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// funcs are the functions available to every template of a Queue, in
+// addition to the builtin ones of [template]. The variables of receivers
+// are strings, so the functions which take numbers or times parse them
+// from strings as well.
+//
+// The value on which a function operates is its last argument, so that
+// it may be piped, e.g. {{.name | default "friend" | title}}.
+var funcs = template.FuncMap{
+	"default":  defaultValue,
+	"title":    title,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"now":      time.Now,
+	"tz":       inTimezone,
+	"date":     formatDate,
+	"number":   formatNumber,
+	"currency": formatCurrency,
+	"plural":   plural,
+	"url":      buildURL,
+}
+
+// defaultValue returns value, or def if value is blank. A missing
+// variable fails the template before it reaches a function, so optional
+// variables are read with index, e.g. {{index . "nickname" | default "friend"}}.
+func defaultValue(def, value string) string {
+	if strings.TrimSpace(value) == "" {
+		return def
+	}
+	return value
+}
+
+// title capitalises the first letter of each word of s, and lowercases
+// the rest of it.
+func title(s string) string {
+	b := new(strings.Builder)
+	b.Grow(len(s))
+	start := true
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r) || r == '-':
+			start = true
+		case start:
+			r = unicode.ToTitle(r)
+			start = false
+		default:
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// timeLayouts are the layouts in which times are parsed from strings.
+var timeLayouts = []string{time.RFC3339, time.DateTime, time.DateOnly}
+
+// toTime converts value, a time or a string in one of [timeLayouts], to
+// a time. Strings without an offset are parsed in loc.
+func toTime(value any, loc *time.Location) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.ParseInLocation(layout, strings.TrimSpace(v), loc); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time: %q", v)
+	default:
+		return time.Time{}, fmt.Errorf("invalid time: %v", value)
+	}
+}
+
+// inTimezone returns value in the IANA timezone name, such as
+// "Europe/Paris", e.g. {{.signup | tz "Europe/Paris" | date "15:04 MST"}}.
+func inTimezone(name string, value any) (time.Time, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := toTime(value, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.In(loc), nil
+}
+
+// formatDate formats value with the layout of [time.Time.Format], e.g.
+// {{.signup | date "2 January 2006"}}.
+func formatDate(layout string, value any) (string, error) {
+	t, err := toTime(value, time.UTC)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(layout), nil
+}
+
+// toFloat converts value, a number or a string, to a float64.
+func toFloat(value any) (float64, error) {
+	switch v := value.(type) {
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number: %q", v)
+		}
+		return f, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	default:
+		return 0, fmt.Errorf("invalid number: %v", value)
+	}
+}
+
+// formatNumber formats value with the given number of decimals, and
+// with its thousands separated by commas, e.g. {{.total | number 2}}
+// prints "1,234.50".
+func formatNumber(decimals int, value any) (string, error) {
+	f, err := toFloat(value)
+	if err != nil {
+		return "", err
+	}
+	if decimals < 0 {
+		return "", errors.New("number: decimals must not be negative")
+	}
+
+	s := strconv.FormatFloat(math.Abs(f), 'f', decimals, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+
+	b := new(strings.Builder)
+	if f < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	if frac != "" {
+		b.WriteByte('.')
+		b.WriteString(frac)
+	}
+	return b.String(), nil
+}
+
+// currencies are the symbols and decimals of common currencies.
+var currencies = map[string]struct {
+	symbol   string
+	decimals int
+}{
+	"USD": {"$", 2},
+	"EUR": {"€", 2},
+	"GBP": {"£", 2},
+	"INR": {"₹", 2},
+	"JPY": {"¥", 0},
+}
+
+// formatCurrency formats value as an amount of the currency with the
+// ISO 4217 code, e.g. {{.total | currency "EUR"}} prints "€1,234.50".
+// Currencies without a known symbol are followed by their code, as in
+// "1,234.50 CHF".
+func formatCurrency(code string, value any) (string, error) {
+	code = strings.ToUpper(code)
+	c, ok := currencies[code]
+	if !ok {
+		s, err := formatNumber(2, value)
+		if err != nil {
+			return "", err
+		}
+		return s + " " + code, nil
+	}
+
+	s, err := formatNumber(c.decimals, value)
+	if err != nil {
+		return "", err
+	}
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		return "-" + c.symbol + rest, nil
+	}
+	return c.symbol + s, nil
+}
+
+// plural returns singular if count is 1, and plural otherwise, e.g.
+// {{.count}} {{.count | plural "item" "items"}}.
+func plural(singular, plural string, count any) (string, error) {
+	f, err := toFloat(count)
+	if err != nil {
+		return "", err
+	}
+	if f == 1 {
+		return singular, nil
+	}
+	return plural, nil
+}
+
+// buildURL adds query parameters to base, given as pairs of keys and
+// values, escaping them, e.g. {{url "https://example.com/track" "id" .id}}.
+// Only http, https and mailto URLs may be built.
+func buildURL(base string, pairs ...string) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", errors.New("url: parameters must be pairs of keys and values")
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+	default:
+		return "", fmt.Errorf("url: unsafe scheme: %q", u.Scheme)
+	}
+
+	query := u.Query()
+	for i := 0; i < len(pairs); i += 2 {
+		if pairs[i] == "" {
+			return "", fmt.Errorf("url: invalid parameter name: %q", pairs[i])
+		}
+		query.Add(pairs[i], pairs[i+1])
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
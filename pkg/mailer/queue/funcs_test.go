@@ -0,0 +1,189 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	htmltemplate "html/template"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestDefaultValue(t *testing.T) {
+	if got := defaultValue("friend", " "); got != "friend" {
+		t.Fatalf("expected a blank value to be replaced, got: %q", got)
+	}
+	if got := defaultValue("friend", "Sarah"); got != "Sarah" {
+		t.Fatalf("expected a value to be kept, got: %q", got)
+	}
+}
+
+func TestTitle(t *testing.T) {
+	for in, want := range map[string]string{
+		"john doe":         "John Doe",
+		"JANE SMITH-JONES": "Jane Smith-Jones",
+		"émile  zola":      "Émile  Zola",
+		"":                 "",
+	} {
+		if got := title(in); got != want {
+			t.Fatalf("title(%q): expected %q, got: %q", in, want, got)
+		}
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	for _, value := range []any{"2024-05-01", "2024-05-01 00:00:00", "2024-05-01T00:00:00Z", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)} {
+		got, err := formatDate("2 January 2006", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "1 May 2024" {
+			t.Fatalf("date(%v): expected 1 May 2024, got: %s", value, got)
+		}
+	}
+
+	if _, err := formatDate(time.DateOnly, "yesterday"); err == nil {
+		t.Fatal("expected an invalid time to fail")
+	}
+}
+
+func TestInTimezone(t *testing.T) {
+	tm, err := inTimezone("Asia/Tokyo", "2024-05-01T12:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tm.Format("15:04 MST"); got != "21:00 JST" {
+		t.Fatalf("expected the time to be converted, got: %s", got)
+	}
+
+	// times without an offset are in the timezone
+	tm, err = inTimezone("Asia/Tokyo", "2024-05-01 12:00:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tm.UTC().Format(time.DateTime); got != "2024-05-01 03:00:00" {
+		t.Fatalf("expected the time to be parsed in the timezone, got: %s", got)
+	}
+
+	if _, err := inTimezone("Mars/Olympus", "2024-05-01"); err == nil {
+		t.Fatal("expected an unknown timezone to fail")
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	for _, tc := range []struct {
+		decimals int
+		value    any
+		want     string
+	}{
+		{2, "1234.5", "1,234.50"},
+		{0, "1234567", "1,234,567"},
+		{1, -9876.54, "-9,876.5"},
+		{2, "-0.001", "0.00"},
+		{0, 999, "999"},
+	} {
+		got, err := formatNumber(tc.decimals, tc.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("number %d %v: expected %s, got: %s", tc.decimals, tc.value, tc.want, got)
+		}
+	}
+
+	if _, err := formatNumber(2, "lots"); err == nil {
+		t.Fatal("expected an invalid number to fail")
+	}
+}
+
+func TestFormatCurrency(t *testing.T) {
+	for _, tc := range []struct {
+		code, value, want string
+	}{
+		{"EUR", "1234.5", "€1,234.50"},
+		{"usd", "-20", "-$20.00"},
+		{"JPY", "1500.4", "¥1,500"},
+		{"CHF", "99.9", "99.90 CHF"},
+	} {
+		got, err := formatCurrency(tc.code, tc.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("currency %s %s: expected %s, got: %s", tc.code, tc.value, tc.want, got)
+		}
+	}
+}
+
+func TestPlural(t *testing.T) {
+	for count, want := range map[string]string{"1": "item", "0": "items", "2": "items", "1.5": "items"} {
+		got, err := plural("item", "items", count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("plural %s: expected %s, got: %s", count, want, got)
+		}
+	}
+
+	if _, err := plural("item", "items", "some"); err == nil {
+		t.Fatal("expected an invalid count to fail")
+	}
+}
+
+func TestBuildURL(t *testing.T) {
+	got, err := buildURL("https://example.com/track?campaign=spring", "name", "Sarah & Tom", "next", "/a?b=c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://example.com/track?campaign=spring&name=Sarah+%26+Tom&next=%2Fa%3Fb%3Dc"; got != want {
+		t.Fatalf("expected %s, got: %s", want, got)
+	}
+
+	if _, err := buildURL("javascript:alert(1)"); err == nil {
+		t.Fatal("expected an unsafe scheme to fail")
+	}
+	if _, err := buildURL("https://example.com", "name"); err == nil {
+		t.Fatal("expected an odd number of parameters to fail")
+	}
+}
+
+func TestFuncsInTemplates(t *testing.T) {
+	data := map[string]string{"name": "sarah connor", "count": "3", "total": "1234.5", "id": "a&b"}
+
+	text := template.Must(template.New("text").Funcs(funcs).Parse(
+		`{{.name | title}}, {{.count}} {{.count | plural "item" "items"}} for {{.total | currency "EUR"}}` +
+			` {{index . "nickname" | default "friend" | upper}}`,
+	))
+	got, err := render(text, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Sarah Connor, 3 items for €1,234.50 FRIEND"; got != want {
+		t.Fatalf("expected %q, got: %q", want, got)
+	}
+
+	html := htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap(funcs)).Funcs(htmlFuncs).Parse(
+		`<a href="{{url "https://example.com/order" "id" .id}}">{{.name | title}}</a>`,
+	))
+	got, err = render(html, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, `href="https://example.com/order?id=a%26b"`) {
+		t.Fatalf("unexpected html: %s", got)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+	"github.com/jordan-wright/email"
+)
+
+// examples is resolved before any test changes its working directory.
+var examples, _ = filepath.Abs("../../../examples")
+
+// fakeTransport is a [mailer.Transport] which records the emails sent
+// through it, failing those addressed to the receivers in errs, and all
+// of those of the senders in down as if their server was unreachable.
+type fakeTransport struct {
+	mu   sync.Mutex
+	sent []string
+	errs map[string]error
+	down map[string]bool
+}
+
+func (f *fakeTransport) Send(ctx context.Context, sender *mailer.Sender, emails []*email.Email) []mailer.Result {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	results := make([]mailer.Result, len(emails))
+	for i, e := range emails {
+		if f.down[sender.Email] {
+			results[i].Err = fmt.Errorf("%w: %w: connection refused", mailer.ErrNotSent, mailer.ErrConnection)
+			continue
+		}
+		if err, ok := f.errs[e.To[0]]; ok {
+			results[i].Err = err
+			continue
+		}
+		f.sent = append(f.sent, e.To[0])
+		results[i].Code, results[i].Response, results[i].Time = 250, "2.0.0 OK", time.Now()
+	}
+	return results
+}
+
+// chdirTemp moves the test into a temporary directory, so that the files
+// written by a run do not end up in the package.
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(pwd) })
+}
+
+// newTestQueue returns a queue on a virtual clock which sends a "Hello"
+// text email from sender@example.com to receivers 0@example.com and up
+// through a [fakeTransport], after applying opts.
+func newTestQueue(t *testing.T, receivers int, opts ...OptFunc) *Queue {
+	t.Helper()
+
+	q := defaultQueue()
+	q.clock = &virtualClock{now: time.Now()}
+	q.transport = &fakeTransport{}
+	q.text = template.Must(template.New("text").Parse("Hello"))
+	if err := withSenders("sender@example.com")(q); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < receivers; i++ {
+		q.receivers = append(q.receivers, &mailer.Receiver{Email: fmt.Sprintf("%d@example.com", i)})
+	}
+
+	for _, opt := range opts {
+		if err := opt(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return q
+}
+
+// withSenders replaces the senders of a test queue.
+func withSenders(emails ...string) OptFunc {
+	return func(q *Queue) error {
+		q.senders = nil
+		q.status = make(map[string]*Stats)
+		for _, e := range emails {
+			q.senders = append(q.senders, &mailer.Sender{Email: e})
+			q.status[e] = &Stats{Sender: e}
+		}
+		return nil
+	}
+}
+
+// withClock starts a test queue at now.
+func withClock(now time.Time) OptFunc {
+	return func(q *Queue) error {
+		q.clock = &virtualClock{now: now}
+		return nil
+	}
+}
+
+// newExampleQueue creates a queue with [New] from the senders, receivers
+// and text template in the examples directory.
+func newExampleQueue(opts ...OptFunc) (*Queue, error) {
+	return New(
+		filepath.Join(examples, "senders.example.csv"),
+		filepath.Join(examples, "receivers.example.csv"),
+		"This is to test the queue functionality",
+		"",
+		filepath.Join(examples, "text_templ.txt"),
+		opts...,
+	)
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"os"
+	"time"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+	"github.com/gocarina/gocsv"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// delivered marks a journal entry for a successfully sent email
+	delivered = "delivered"
+	// failed marks a journal entry for an email that could not be sent
+	failed = "failed"
+)
+
+// JournalEntry represents a single line of the on-disk checkpoint journal
+// which records the outcome of every email sent by a Queue.
+type JournalEntry struct {
+	Time     time.Time `csv:"time"`
+	Status   string    `csv:"status"`
+	Sender   string    `csv:"sender"`
+	Receiver string    `csv:"receiver"`
+}
+
+// journal is an append-only CSV file to which the outcome of each
+// send operation is written as soon as it is known.
+type journal struct {
+	file *os.File
+}
+
+// openJournal opens the journal for appending. Unless the journal is
+// being resumed, any entries from a previous run are discarded.
+func openJournal(filename string, resume bool) (*journal, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(filename, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if info.Size() == 0 {
+		if err := gocsv.Marshal([]*JournalEntry{}, file); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	log.Debug().Str("file", filename).Msg("opened journal")
+	return &journal{file: file}, nil
+}
+
+// record appends an entry for each receiver and syncs the journal
+// to disk, so that it survives an abrupt exit.
+func (j *journal) record(now time.Time, status, sender string, receivers []*mailer.Receiver) error {
+	if j == nil || len(receivers) == 0 {
+		return nil
+	}
+
+	entries := make([]*JournalEntry, 0, len(receivers))
+	for _, r := range receivers {
+		entries = append(entries, &JournalEntry{
+			Time:     now,
+			Status:   status,
+			Sender:   sender,
+			Receiver: r.Email,
+		})
+	}
+
+	if err := gocsv.MarshalWithoutHeaders(entries, j.file); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// resume restores the state of the Queue from the entries of a journal
+// written by a previous run. Receivers which have already been delivered
+// to are dropped, the counters of each sender are restored, and the
+// recent deliveries are kept so that they count against the rate limits
+// and daily quotas of their senders.
+func (q *Queue) resume(entries []*JournalEntry) {
+	now := q.clock.Now()
+	done := make(map[string]bool)
+
+	for _, entry := range entries {
+		if entry.Status != delivered {
+			continue
+		}
+		done[entry.Receiver] = true
+
+		status, ok := q.status[entry.Sender]
+		if !ok {
+			continue
+		}
+		status.Total++
+
+		if now.Sub(entry.Time) < historyWindow {
+			q.history = append(q.history, entry)
+		}
+	}
+
+	receivers := make([]*mailer.Receiver, 0, len(q.receivers))
+	for _, r := range q.receivers {
+		if done[r.Email] {
+			continue
+		}
+		receivers = append(receivers, r)
+	}
+
+	log.Info().
+		Int("delivered", len(q.receivers)-len(receivers)).
+		Int("remaining", len(receivers)).
+		Msg("resuming from journal")
+	q.receivers = receivers
+}
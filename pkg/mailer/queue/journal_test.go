@@ -0,0 +1,71 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+)
+
+func TestJournalResume(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "journal.csv")
+	sender := &mailer.Sender{Email: "sender@example.com"}
+	receivers := []*mailer.Receiver{
+		{Email: "a@example.com"},
+		{Email: "b@example.com"},
+		{Email: "c@example.com"},
+	}
+
+	j, err := openJournal(file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.record(time.Now(), delivered, sender.Email, receivers[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.record(time.Now(), failed, sender.Email, receivers[1:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	q := defaultQueue()
+	q.senders = []*mailer.Sender{sender}
+	q.receivers = receivers
+	q.status[sender.Email] = &Stats{Sender: sender.Email}
+
+	if err := WithResume(file)(q); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(q.receivers) != 2 || q.receivers[0].Email != "b@example.com" {
+		t.Fatalf("expected delivered receiver to be skipped, got: %+v", q.receivers)
+	}
+
+	status := q.status[sender.Email]
+	if status.Total != 1 {
+		t.Fatalf("expected counters to be restored, got: %+v", status)
+	}
+
+	// the default limit of 2 emails a minute leaves room for 1 more
+	q.newLimiters()
+	if n := q.limiters[sender.Email].available(time.Now()); n != 1 {
+		t.Fatalf("expected the delivery to count against the rate limits, got: %d available", n)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	htmltemplate "html/template"
+	"path/filepath"
+	"text/template"
+
+	"github.com/rs/zerolog/log"
+)
+
+// textPatterns and htmlPattern match the files of a template directory
+// which are shared by the text and HTML content respectively.
+var (
+	textPatterns = []string{"*.txt", "*.tmpl"}
+	htmlPattern  = "*.html"
+)
+
+// glob returns the files in dir which match any of patterns.
+func glob(dir string, patterns ...string) ([]string, error) {
+	var files []string
+	for _, p := range patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, p))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// loadTemplateDir adds the layouts and partials of the template
+// directory to the text and HTML content of the Queue. The content is
+// parsed after them, so that the templates it defines, such as the
+// blocks of a layout, take precedence.
+func (q *Queue) loadTemplateDir() error {
+	if q.templateDir == "" {
+		return nil
+	}
+
+	files, err := glob(q.templateDir, textPatterns...)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 && q.text != nil {
+		shared, err := template.New("").Option("missingkey=error").Funcs(funcs).ParseFiles(files...)
+		if err != nil {
+			return err
+		}
+		if q.text, err = extendText(shared, q.text); err != nil {
+			return err
+		}
+		log.Debug().Str("dir", q.templateDir).Int("files", len(files)).Msg("loaded text templates")
+	}
+
+	files, err = glob(q.templateDir, htmlPattern)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 && q.html != nil {
+		shared, err := htmltemplate.New("").
+			Option("missingkey=error").
+			Funcs(htmltemplate.FuncMap(funcs)).
+			Funcs(htmlFuncs).
+			ParseFiles(files...)
+		if err != nil {
+			return err
+		}
+		if q.html, err = extendHTML(shared, q.html); err != nil {
+			return err
+		}
+		log.Debug().Str("dir", q.templateDir).Int("files", len(files)).Msg("loaded html templates")
+	}
+
+	return nil
+}
+
+// extendText returns t in a copy of shared, along with the templates t
+// defines, which replace those of shared with the same names.
+func extendText(shared, t *template.Template) (*template.Template, error) {
+	set, err := shared.Clone()
+	if err != nil {
+		return nil, err
+	}
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree == nil {
+			continue
+		}
+		if _, err := set.AddParseTree(tmpl.Name(), tmpl.Tree); err != nil {
+			return nil, err
+		}
+	}
+	return set.Lookup(t.Name()), nil
+}
+
+// extendHTML is like [extendText] for HTML templates.
+func extendHTML(shared, t *htmltemplate.Template) (*htmltemplate.Template, error) {
+	set, err := shared.Clone()
+	if err != nil {
+		return nil, err
+	}
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree == nil {
+			continue
+		}
+		if _, err := set.AddParseTree(tmpl.Name(), tmpl.Tree); err != nil {
+			return nil, err
+		}
+	}
+	return set.Lookup(t.Name()), nil
+}
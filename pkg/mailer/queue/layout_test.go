@@ -0,0 +1,90 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+)
+
+func TestTemplateDir(t *testing.T) {
+	dir := t.TempDir()
+	shared := filepath.Join(dir, "shared")
+	if err := os.Mkdir(shared, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{
+		"shared/layout.html": `{{define "layout"}}<body>{{block "content" .}}default{{end}}{{template "footer" .}}</body>{{end}}`,
+		"shared/footer.html": `{{define "footer"}}<p>{{.company}}</p>{{end}}`,
+		"shared/footer.txt":  `{{define "footer"}}-- {{.company}}{{end}}`,
+		"body.html":          `{{template "layout" .}}{{define "content"}}Hi {{.name}}{{end}}`,
+		"body.txt":           "Hi {{.name}}\n{{template \"footer\" .}}",
+		"receivers.csv":      "email,variables\nsarah@example.com,name=Sarah;company=<Hermes>\nmark@example.com,name=Mark\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the template dir is loaded after the html, whatever the order
+	// of the options
+	q, err := New(
+		"",
+		filepath.Join(dir, "receivers.csv"),
+		"Hi {{.name}}",
+		"",
+		filepath.Join(dir, "body.txt"),
+		WithTemplateDir(shared),
+		WithHTML(filepath.Join(dir, "body.html")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := &task{
+		sender:    &mailer.Sender{Email: "sender@example.com"},
+		receivers: q.receivers[:1],
+		subject:   q.subject,
+		text:      q.text,
+		html:      q.html,
+	}
+	emails, errs := createEmails(task, "sender@example.com")
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(emails[0].Text), "Hi Sarah\n-- <Hermes>"; got != want {
+		t.Fatalf("unexpected text:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := string(emails[0].HTML), "<body>Hi Sarah<p>&lt;Hermes&gt;</p></body>"; got != want {
+		t.Fatalf("unexpected html:\n%s\nwant:\n%s", got, want)
+	}
+
+	// the variables of the partials are validated as well
+	expected := []Problem{{Row: 2, Receiver: "mark@example.com", Missing: []string{"company"}}}
+	if problems := q.Validate(); !reflect.DeepEqual(problems, expected) {
+		t.Fatalf("expected:\n%+v\ngot:\n%+v", expected, problems)
+	}
+
+	if _, err := New("", filepath.Join(dir, "receivers.csv"), "", "", filepath.Join(dir, "body.txt"), WithTemplateDir(filepath.Join(dir, "body.txt"))); err == nil {
+		t.Fatal("expected a template dir which is a file to fail")
+	}
+}
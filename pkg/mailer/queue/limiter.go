@@ -0,0 +1,179 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"math"
+	"time"
+)
+
+// Limits are the maximum numbers of emails a single sender may send in
+// each period. A zero limit is unlimited.
+//
+// PerDay is counted per calendar day in Location, resetting at midnight,
+// or over the last 24 hours if Rolling is set.
+type Limits struct {
+	PerSecond, PerMinute, PerHour, PerDay uint
+	Rolling                               bool
+	Location                              *time.Location
+}
+
+// rate is a limit on the number of emails sent over time.
+type rate interface {
+	// available returns the number of emails which may be sent at now.
+	available(now time.Time) uint
+	// wait returns the time from now until an email may be sent.
+	wait(now time.Time) time.Duration
+	// take records that n emails were sent at the given time.
+	take(at time.Time, n uint)
+	// refund returns n of the emails last taken, which were not sent.
+	refund(n uint)
+}
+
+// bucket is a token bucket which holds up to limit tokens, and refills
+// at a rate of limit tokens per period.
+//
+// Rather than counting tokens, the bucket holds credit, which is the
+// time it has been refilling for, up to a full period. Each token costs
+// period/limit of credit, which keeps the arithmetic exact.
+type bucket struct {
+	period, cost time.Duration
+	credit       time.Duration
+	last         time.Time
+}
+
+func newBucket(limit uint, period time.Duration, now time.Time) *bucket {
+	return &bucket{period: period, cost: period / time.Duration(limit), credit: period, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.credit = min(b.period, b.credit+now.Sub(b.last))
+		b.last = now
+	}
+}
+
+// available returns the number of tokens in the bucket at now.
+func (b *bucket) available(now time.Time) uint {
+	b.refill(now)
+	if b.credit < b.cost {
+		return 0
+	}
+	return uint(b.credit / b.cost)
+}
+
+// wait returns the time from now until a token is available.
+func (b *bucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.credit >= b.cost {
+		return 0
+	}
+	return b.cost - b.credit
+}
+
+// take removes n tokens from the bucket at the given time. The bucket
+// may be overdrawn, which delays the next token accordingly.
+func (b *bucket) take(at time.Time, n uint) {
+	b.refill(at)
+	b.credit -= time.Duration(n) * b.cost
+}
+
+// refund returns n tokens to the bucket, up to its capacity.
+func (b *bucket) refund(n uint) {
+	b.credit = min(b.period, b.credit+time.Duration(n)*b.cost)
+}
+
+// limiter enforces the [Limits] of a single sender, with a bucket for
+// each of them but the daily one, which is a [dailyQuota].
+type limiter struct {
+	buckets []rate
+}
+
+func newLimiter(l Limits, now time.Time) *limiter {
+	lim := new(limiter)
+	for _, b := range []struct {
+		limit  uint
+		period time.Duration
+	}{
+		{l.PerSecond, time.Second},
+		{l.PerMinute, time.Minute},
+		{l.PerHour, time.Hour},
+	} {
+		if b.limit > 0 {
+			lim.buckets = append(lim.buckets, newBucket(b.limit, b.period, now))
+		}
+	}
+	if l.PerDay > 0 {
+		lim.buckets = append(lim.buckets, newDailyQuota(l.PerDay, l.Rolling, l.Location))
+	}
+	return lim
+}
+
+// available returns the number of emails which may be sent at now.
+func (l *limiter) available(now time.Time) uint {
+	n := uint(math.MaxUint)
+	for _, b := range l.buckets {
+		n = min(n, b.available(now))
+	}
+	return n
+}
+
+// next returns the earliest time, from now, at which an email may be
+// sent.
+func (l *limiter) next(now time.Time) time.Time {
+	var wait time.Duration
+	for _, b := range l.buckets {
+		wait = max(wait, b.wait(now))
+	}
+	return now.Add(wait)
+}
+
+// take records that n emails were sent at the given time.
+func (l *limiter) take(at time.Time, n uint) {
+	for _, b := range l.buckets {
+		b.take(at, n)
+	}
+}
+
+// refund records that n of the emails last taken were not sent, so
+// that they do not count against the limits.
+func (l *limiter) refund(n uint) {
+	for _, b := range l.buckets {
+		b.refund(n)
+	}
+}
+
+// newLimiters creates the limiter of each sender, and replays the
+// deliveries of a resumed run and of the quota log, so that their sends
+// count against them.
+func (q *Queue) newLimiters() {
+	history := q.recentSends()
+	now := q.clock.Now()
+	start := now
+	if len(history) > 0 && history[0].Time.Before(now) {
+		start = history[0].Time
+	}
+
+	q.limiters = make(map[string]*limiter, len(q.senders))
+	for _, sender := range q.senders {
+		q.limiters[sender.Email] = newLimiter(q.limits, start)
+	}
+
+	for _, entry := range history {
+		if lim, ok := q.limiters[entry.Sender]; ok {
+			lim.take(entry.Time, 1)
+		}
+	}
+}
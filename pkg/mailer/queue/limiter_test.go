@@ -0,0 +1,103 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	lim := newLimiter(Limits{PerSecond: 2, PerHour: 3}, now)
+
+	if n := lim.available(now); n != 2 {
+		t.Fatalf("expected the per-second limit to allow 2, got: %d", n)
+	}
+	lim.take(now, 2)
+	if n := lim.available(now); n != 0 {
+		t.Fatalf("expected no emails to be available, got: %d", n)
+	}
+	if next := lim.next(now); !next.Equal(now.Add(500 * time.Millisecond)) {
+		t.Fatalf("expected the next email half a second later, got: %v", next.Sub(now))
+	}
+
+	now = now.Add(time.Second)
+	lim.take(now, 1)
+	if next := lim.next(now); !next.Equal(now.Add(20 * time.Minute).Add(-time.Second)) {
+		t.Fatalf("expected the next email once the hourly bucket refills, got: %v", next.Sub(now))
+	}
+	if n := lim.available(now.Add(time.Minute)); n != 0 {
+		t.Fatalf("expected the per-hour limit to be exhausted, got: %d", n)
+	}
+
+	if n := newLimiter(Limits{}, now).available(now); n == 0 {
+		t.Fatal("expected no limits to be unlimited")
+	}
+}
+
+func TestRateOptions(t *testing.T) {
+	q := defaultQueue()
+	for _, opt := range []OptFunc{WithRateSecond(0), WithRateMinute(0), WithRateHourly(0), WithRateDaily(0)} {
+		if err := opt(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.limits.PerSecond != 0 || q.limits.PerMinute != 0 || q.limits.PerHour != 0 || q.limits.PerDay != 0 {
+		t.Fatalf("expected 0 to leave every rate unlimited, got: %+v", q.limits)
+	}
+}
+
+func TestUnsentRefund(t *testing.T) {
+	chdirTemp(t)
+
+	q := newTestQueue(t, 2)
+	q.transport = &fakeTransport{down: map[string]bool{"sender@example.com": true}}
+	q.limits = Limits{PerDay: 4}
+
+	if err := q.Run(); err == nil {
+		t.Fatal("expected the run to fail once the sender has errored too many times")
+	}
+
+	// none of the emails were sent, so none count against the quota
+	if n := q.limiters["sender@example.com"].available(q.clock.Now()); n != 4 {
+		t.Fatalf("expected the unsent emails to be refunded, got: %d available", n)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	chdirTemp(t)
+
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	q := newTestQueue(t, 7, withClock(start), WithRateMinute(3))
+	q.dryRun = true
+	q.batchSize = 2
+
+	if err := q.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the burst of 3 is sent in batches of 2 and 1, after which the
+	// bucket refills at one email every 20 seconds
+	expected := []time.Duration{0, 0, 0, 20 * time.Second, 40 * time.Second, 60 * time.Second, 80 * time.Second}
+	if len(q.plan) != len(expected) {
+		t.Fatalf("expected %d planned emails, got: %d", len(expected), len(q.plan))
+	}
+	for i, e := range q.plan {
+		if d := e.Time.Sub(start); d != expected[i] {
+			t.Fatalf("expected email %d to be sent after %v, got: %v", i, expected[i], d)
+		}
+	}
+}
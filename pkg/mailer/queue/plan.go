@@ -0,0 +1,47 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"time"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+)
+
+// PlanEntry represents a single email scheduled during a dry run, with
+// the simulated time at which it would have been sent.
+type PlanEntry struct {
+	Time     time.Time `csv:"time"`
+	Sender   string    `csv:"sender"`
+	Host     string    `csv:"host"`
+	Receiver string    `csv:"receiver"`
+}
+
+// schedule adds the receivers of a task to the plan of a dry run.
+func (q *Queue) schedule(sender *mailer.Sender, receivers []*mailer.Receiver) {
+	if !q.dryRun {
+		return
+	}
+
+	host := q.host
+	if sender.Host != "" {
+		host = sender.Host
+	}
+
+	now := q.clock.Now()
+	for _, r := range receivers {
+		q.plan = append(q.plan, &PlanEntry{Time: now, Sender: sender.Email, Host: host, Receiver: r.Email})
+	}
+}
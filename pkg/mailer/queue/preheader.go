@@ -0,0 +1,46 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"regexp"
+)
+
+// bodyTag matches the opening tag of the body of an HTML document.
+var bodyTag = regexp.MustCompile(`(?i)<body[^>]*>`)
+
+// preheaderStyle hides the preheader from the rendered email, while
+// leaving it to be shown next to the subject by email clients.
+const preheaderStyle = "display:none;font-size:1px;line-height:1px;max-height:0;max-width:0;opacity:0;overflow:hidden;mso-hide:all;"
+
+// insertPreheader inserts text as a hidden element at the start of the
+// body of html, or of html itself if it has no body tag.
+func insertPreheader(html []byte, text string) []byte {
+	div := []byte(`<div style="` + preheaderStyle + `">` + htmltemplate.HTMLEscapeString(text) + `</div>`)
+
+	i := 0
+	if loc := bodyTag.FindIndex(html); loc != nil {
+		i = loc[1]
+	}
+
+	var b bytes.Buffer
+	b.Grow(len(html) + len(div))
+	b.Write(html[:i])
+	b.Write(div)
+	b.Write(html[i:])
+	return b.Bytes()
+}
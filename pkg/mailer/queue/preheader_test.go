@@ -0,0 +1,34 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInsertPreheader(t *testing.T) {
+	div := `<div style="` + preheaderStyle + `">Sale &amp; more</div>`
+
+	html := insertPreheader([]byte(`<html><BODY class="main"><p>Hi</p></BODY></html>`), "Sale & more")
+	if got, want := string(html), `<html><BODY class="main">`+div+`<p>Hi</p></BODY></html>`; got != want {
+		t.Fatalf("unexpected html:\n%s\nwant:\n%s", got, want)
+	}
+
+	html = insertPreheader([]byte(`<p>Hi</p>`), "Sale & more")
+	if !strings.HasPrefix(string(html), div) {
+		t.Fatalf("expected the preheader to be prepended to a fragment, got: %s", html)
+	}
+}
@@ -15,8 +15,12 @@
 package queue
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sync"
@@ -24,45 +28,92 @@ import (
 	"time"
 
 	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/unsubscribe"
 	"github.com/gocarina/gocsv"
 	"github.com/rs/zerolog/log"
 )
 
 type task struct {
-	sender                     *mailer.Sender
-	receivers                  []*mailer.Receiver
-	subject, host, readReceipt string
-	text, html                 *template.Template
+	sender               *mailer.Sender
+	receivers            []*mailer.Receiver
+	messageIDs           []string
+	readReceipt, htmlDir string
+	subject, preheader   *template.Template
+	text                 *template.Template
+	html                 *htmltemplate.Template
+	attachments          []*attachment
+	maxAttachmentSize    int64
+	embedImages          bool
+	unsubscribe          *unsubscribe.Config
+	verp                 *mailer.VERP
+	campaign             string
+	created              time.Time
 }
 
 // Queue represents a worker queue performing email send operations.
 type Queue struct {
 	senders                     []*mailer.Sender
 	receivers                   []*mailer.Receiver
-	subject, host, readReceipts string
-	text, html                  *template.Template
-	perMinute, perDay           uint16
-	start                       time.Time
+	rows                        map[*mailer.Receiver]int
+	host, readReceipts, htmlDir string
+	subject, preheader          *template.Template
+	text                        *template.Template
+	html                        *htmltemplate.Template
+	attachments                 []*attachment
+	maxAttachmentSize           int64
+	embedImages                 bool
+	templateDir                 string
+	unsubscribe                 *unsubscribe.Config
+	verp                        *mailer.VERP
+	campaign                    string
+	limits                      Limits
+	batchSize                   uint
+	limiters                    map[string]*limiter
+	history, sent               []*JournalEntry
 	status                      map[string]*Stats
 	workers                     uint8
 	auth                        mailer.Auth
-	failures                    []*mailer.Receiver
+	oauth2                      *mailer.OAuth2Config
+	failed                      []*FailedReceiver
+	suppressed                  []*mailer.Receiver
+	retries                     []*retry
+	attempts                    map[*mailer.Receiver]uint8
+	messageIDs                  map[*mailer.Receiver]string
+	maxAttempts                 uint8
+	retryDelay                  time.Duration
 	errorThreshold, errorCount  uint8
+	clock                       clock
+	transport                   mailer.Transport
+	port                        uint16
+	security                    mailer.Security
+	tlsConfig                   *tls.Config
+	dir                         string
+	dryRun, mbox                bool
+	signer                      mailer.Signer
+	plan                        []*PlanEntry
+	journalFile                 string
+	journal                     *journal
+	deliveryFile                string
+	deliveries                  *deliveryLog
+	quotaFile                   string
+	quotaLog                    *journal
+	resumed                     bool
 }
 
-func (q *Queue) isTomorrow() bool {
-	now := time.Now()
-	if now.Unix() > q.start.Add(24*time.Hour).Unix() {
-		q.start = now
-		return true
-	}
-	return false
-}
-
+// load reads the senders and receivers of the Queue. The senders may be
+// omitted for a Queue which is only validated.
 func (q *Queue) load(senders, receivers string) error {
-	s, err := mailer.ReadFile[mailer.Sender](senders)
-	if err != nil {
-		return err
+	var s []*mailer.Sender
+	if senders != "" {
+		var err error
+		if s, err = mailer.ReadFile[mailer.Sender](senders); err != nil {
+			return err
+		}
+	}
+	for _, sender := range s {
+		if err := sender.Validate(); err != nil {
+			return err
+		}
 	}
 
 	r, err := mailer.ReadFile[mailer.Receiver](receivers)
@@ -72,6 +123,13 @@ func (q *Queue) load(senders, receivers string) error {
 	q.senders = s
 	q.receivers = r
 
+	// receivers may be dropped before they are validated, so their rows
+	// are kept for reporting
+	q.rows = make(map[*mailer.Receiver]int, len(r))
+	for i, receiver := range r {
+		q.rows[receiver] = i + 1
+	}
+
 	return nil
 }
 
@@ -80,6 +138,19 @@ func (q *Queue) collectResults(res chan workerResult, wg *sync.WaitGroup) error
 	close(res)
 
 	for res := range res {
+		now := q.clock.Now()
+		if err := q.journal.record(now, delivered, res.sender, res.delivered); err != nil {
+			return err
+		}
+		if err := q.quotaLog.record(now, delivered, res.sender, res.delivered); err != nil {
+			return err
+		}
+		for _, receiver := range res.delivered {
+			if err := q.logDelivery(&res, receiver, delivered, nil); err != nil {
+				return err
+			}
+		}
+
 		switch res.kind {
 		case success:
 			if q.errorCount > 0 {
@@ -87,14 +158,85 @@ func (q *Queue) collectResults(res chan workerResult, wg *sync.WaitGroup) error
 			}
 			status := q.status[res.sender]
 			status.increment(res.sent)
-			status.setTimeout(1 * time.Minute)
 			log.Debug().Str("sender", res.sender).Uint("sent", res.sent).Msg("send success")
 
 		case failure:
-			log.Error().Str("from", res.sender).Uint("sent", res.sent).Err(res.error).Msg("send failure")
 			status := q.status[res.sender]
-			status.incrementFailed(uint(len(res.receivers)))
-			q.failures = append(q.failures, res.receivers...)
+			status.increment(res.sent)
+
+			// the number of failures which count against the sender
+			var failures int
+			var connFailed bool
+			for i, receiver := range res.receivers {
+				err := res.errs[i]
+				if errors.Is(err, mailer.ErrNotSent) {
+					// the email is sent later, so it does not count
+					// against the limits of the sender now
+					q.receivers = append(q.receivers, receiver)
+					if lim := q.limiters[res.sender]; lim != nil {
+						lim.refund(1)
+					}
+					// the receivers are sent by another sender, but a
+					// failed connection counts against this one, once
+					if errors.Is(err, mailer.ErrConnection) && !connFailed {
+						connFailed = true
+						failures++
+					}
+					continue
+				}
+
+				serr := mailer.ClassifyError(err)
+				outcome := failed
+				if serr.Class == mailer.Transient && q.attempts[receiver]+1 < q.maxAttempts {
+					outcome = deferred
+				}
+				if err := q.logDelivery(&res, receiver, outcome, err); err != nil {
+					return err
+				}
+
+				if serr.Class == mailer.Transient && q.retry(receiver) {
+					log.Warn().
+						Str("from", res.sender).
+						Str("to", receiver.Email).
+						Uint8("attempt", q.attempts[receiver]).
+						Err(err).
+						Msg("transient failure, retrying")
+					continue
+				}
+
+				if err := q.journal.record(q.clock.Now(), failed, res.sender, res.receivers[i:i+1]); err != nil {
+					return err
+				}
+				status.incrementFailed(1)
+
+				// transient failures have been counted by q.retry
+				attempts := q.attempts[receiver]
+				if serr.Class != mailer.Transient {
+					attempts++
+				}
+				q.fail(receiver, res.sender, err, attempts)
+
+				if errors.Is(err, errMessage) {
+					// the email was never sent, so it neither counts
+					// against the limits nor the sender
+					log.Error().Str("from", res.sender).Str("to", receiver.Email).Err(err).Msg("invalid email")
+					if lim := q.limiters[res.sender]; lim != nil {
+						lim.refund(1)
+					}
+					continue
+				}
+				if serr.Class == mailer.Transient || serr.Recipient() {
+					log.Error().Str("from", res.sender).Str("to", receiver.Email).Err(err).Msg("permanent failure")
+					continue
+				}
+				failures++
+			}
+
+			if failures == 0 {
+				continue
+			}
+
+			log.Error().Str("from", res.sender).Uint("sent", res.sent).Err(res.error).Msg("send failure")
 			q.errorCount++
 
 			if q.errorCount >= q.errorThreshold {
@@ -115,10 +257,16 @@ func SaveResults[T mailer.CSVData](data []*T, filename string) (err error) {
 		return err
 	}
 
-	filename = filepath.Join(pwd, filename)
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(pwd, filename)
+	}
 	log.Info().Str("file", filename).Msg("saving results")
+	if err = os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+
 	var file *os.File
-	file, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, os.ModePerm)
+	file, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
 	if err != nil {
 		return err
 	}
@@ -138,105 +286,172 @@ func SaveResults[T mailer.CSVData](data []*T, filename string) (err error) {
 // Run initates the mail queue and performs all the specified
 // operations based off of the given queue configuration.
 func (q *Queue) Run() error {
+	return q.RunContext(context.Background())
+}
+
+// RunContext is like [Queue.Run] but stops scheduling new send operations
+// once ctx is done. Emails which are already being sent are allowed to
+// finish, after which the results of the run are saved and the cause of
+// the cancellation is returned.
+func (q *Queue) RunContext(ctx context.Context) (err error) {
+	if err := q.preflight(); err != nil {
+		return err
+	}
+
+	if q.journalFile != "" {
+		j, err := openJournal(q.journalFile, q.resumed)
+		if err != nil {
+			return err
+		}
+		q.journal = j
+		defer j.close()
+	}
+
+	// the mbox file is appended to, so the emails of a previous dry
+	// run are discarded
+	if q.dryRun && q.mbox {
+		err := os.Remove(filepath.Join(q.dir, mboxFile))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	if q.deliveryFile != "" {
+		// like the mbox file, the log of a dry run only holds that run
+		file := q.deliveryFile
+		if q.dryRun {
+			file = filepath.Join(q.dir, filepath.Base(file))
+		}
+		l, err := openDeliveryLog(file, q.dryRun)
+		if err != nil {
+			return err
+		}
+		q.deliveries = l
+		defer l.close()
+	}
+
+	// the sends of a dry run are simulated, and do not count against
+	// the quotas of later runs
+	if q.quotaFile != "" && !q.dryRun {
+		l, err := q.openQuotaLog()
+		if err != nil {
+			return err
+		}
+		q.quotaLog = l
+		defer l.close()
+	}
+
+	defer func() {
+		err = errors.Join(err,
+			SaveResults[FailedReceiver](q.failed, filepath.Join(q.dir, "errored_receivers.csv")),
+			SaveResults[mailer.Receiver](q.suppressed, filepath.Join(q.dir, "suppressed_receivers.csv")),
+			q.saveStats(filepath.Join(q.dir, "stats.csv")),
+			SaveResults[PlanEntry](q.plan, filepath.Join(q.dir, "plan.csv")),
+		)
+	}()
+
+	q.newLimiters()
+
 	wg := new(sync.WaitGroup)
-	var receiverPtr, senderPtr, skips int
-	for receiverPtr < len(q.receivers) {
+	var receiverPtr, senderPtr int
+	for (receiverPtr < len(q.receivers) || len(q.retries) > 0) && ctx.Err() == nil {
+		next := q.enqueueRetries()
+		if receiverPtr >= len(q.receivers) {
+			dur := next.Sub(q.clock.Now())
+			log.Info().
+				Str("dur", fmt.Sprintf("%.2f min", dur.Minutes())).
+				Int("retries", len(q.retries)).
+				Msg("waiting for retries")
+			q.clock.Sleep(ctx, dur)
+			continue
+		}
+
+		now := q.clock.Now()
 		res := make(chan workerResult, q.workers)
-		for i := 0; i < int(q.workers); i++ {
-			if receiverPtr > len(q.receivers) {
+		var tasks int
+		// the earliest time at which a sender becomes eligible, should
+		// none of them be eligible now
+		var eligible time.Time
+
+		for i := 0; i < len(q.senders) && tasks < int(q.workers); i++ {
+			if receiverPtr >= len(q.receivers) || ctx.Err() != nil {
 				break
 			}
 
 			sender := q.senders[senderPtr]
-			status := q.status[sender.Email]
-			if status.skip {
+			senderPtr = (senderPtr + 1) % len(q.senders)
+
+			if q.status[sender.Email].skip {
 				log.Warn().Msgf("skipping risky sender: %s", sender.Email)
-				time.Sleep(2 * time.Second)
-				senderPtr = (senderPtr + 1) % len(q.senders)
 				continue
 			}
 
-			if status.isTimedOut() {
-				dur := time.Until(*status.timeout)
-				log.Warn().
-					Str("sender", sender.Email).
-					Str("dur", fmt.Sprintf("%.2f min", dur.Minutes())).
-					Msgf("sender timed out")
-
-				skips++
-				if skips >= len(q.senders) {
-					log.Warn().
-						Str("dur", fmt.Sprintf("%.2f min", dur.Minutes())).
-						Msg("sleeping due to repeated skips")
-					time.Sleep(dur)
-					skips = 0
+			lim := q.limiters[sender.Email]
+			n := min(lim.available(now), q.batchSize, uint(len(q.receivers)-receiverPtr))
+			if n == 0 {
+				if t := lim.next(now); eligible.IsZero() || t.Before(eligible) {
+					eligible = t
 				}
-				time.Sleep(2 * time.Second)
-
-				senderPtr = (senderPtr + 1) % len(q.senders)
 				continue
 			}
+			lim.take(now, n)
 
-			if !q.isTomorrow() && status.today >= uint(q.perDay) {
-				status.setTimeout(24 * time.Hour)
-
-				dur := time.Until(*status.timeout)
-				log.Warn().
-					Str("sender", sender.Email).
-					Str("dur", fmt.Sprintf("%.2f min", dur.Minutes())).
-					Msgf("daily limit exceeded")
+			receivers := q.receivers[receiverPtr : receiverPtr+int(n)]
+			receiverPtr += int(n)
 
-				skips++
-				if skips >= len(q.senders) {
-					log.Warn().
-						Str("dur", fmt.Sprintf("%.2f min", dur.Minutes())).
-						Msg("sleeping due to repeated skips")
-					time.Sleep(dur)
-					skips = 0
-				}
-				time.Sleep(2 * time.Second)
-
-				senderPtr = (senderPtr + 1) % len(q.senders)
-				continue
+			task := &task{
+				sender:            sender,
+				receivers:         receivers,
+				messageIDs:        q.assignMessageIDs(sender, receivers),
+				subject:           q.subject,
+				preheader:         q.preheader,
+				readReceipt:       q.readReceipts,
+				text:              q.text,
+				html:              q.html,
+				attachments:       q.attachments,
+				maxAttachmentSize: q.maxAttachmentSize,
+				embedImages:       q.embedImages,
+				htmlDir:           q.htmlDir,
+				unsubscribe:       q.unsubscribe,
+				verp:              q.verp,
+				campaign:          q.campaign,
+				created:           now,
 			}
 
-			var receivers []*mailer.Receiver
-			end := receiverPtr + int(q.perMinute)
+			q.schedule(sender, receivers)
 
-			if end > len(q.receivers) {
-				receivers = q.receivers[receiverPtr:]
-			} else {
-				receivers = q.receivers[receiverPtr:end]
-			}
-			receiverPtr = end
+			wg.Add(1)
+			go worker(ctx, task, q.transport, res, wg)
+			tasks++
+		}
 
-			task := &task{
-				sender:    sender,
-				receivers: receivers,
-				subject:   q.subject,
-				host:      q.host,
-				text:      q.text,
-				html:      q.html,
+		if tasks == 0 {
+			if eligible.IsZero() {
+				if ctx.Err() != nil {
+					break
+				}
+				return errors.New("no senders are available")
 			}
 
-			wg.Add(1)
-			go worker(task, q.auth, res, wg)
-
-			senderPtr = (senderPtr + 1) % len(q.senders)
+			dur := eligible.Sub(now)
+			log.Info().
+				Str("dur", fmt.Sprintf("%.2f min", dur.Minutes())).
+				Msg("waiting for senders to become eligible")
+			q.clock.Sleep(ctx, dur)
+			continue
 		}
 
 		if err := q.collectResults(res, wg); err != nil {
-			return errors.Join(err,
-				SaveResults[mailer.Receiver](q.receivers, "errored_receivers.csv"),
-				SaveResults[Stats](mapToSlice(q.status), "stats.csv"),
-			)
+			return err
 		}
 	}
 
-	return errors.Join(
-		SaveResults[mailer.Receiver](q.receivers, "errored_receivers.csv"),
-		SaveResults[Stats](mapToSlice(q.status), "stats.csv"),
-	)
+	if err := context.Cause(ctx); err != nil {
+		log.Warn().Err(err).Msg("queue stopped before all emails were sent")
+		return err
+	}
+
+	return nil
 }
 
 func mapToSlice(m map[string]*Stats) []*Stats {
@@ -15,13 +15,21 @@
 package queue
 
 import (
+	"context"
+	"errors"
+	htmltemplate "html/template"
+	"net/textproto"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/unsubscribe"
 )
 
 func TestWorker(t *testing.T) {
@@ -61,8 +69,7 @@ func TestWorker(t *testing.T) {
 	task := &task{
 		sender:    sender,
 		receivers: []*mailer.Receiver{receiver},
-		subject:   "This is a test email",
-		host:      host,
+		subject:   template.Must(template.New("subject").Parse("This is a test email")),
 		text:      text,
 	}
 
@@ -70,7 +77,7 @@ func TestWorker(t *testing.T) {
 	res := make(chan workerResult, 1)
 
 	wg.Add(1)
-	go worker(task, mailer.Plain, res, wg)
+	go worker(context.Background(), task, mailer.NewSMTPTransport(host, mailer.Plain), res, wg)
 
 	wg.Wait()
 	close(res)
@@ -123,3 +130,394 @@ func TestQueue(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRunWithTransport(t *testing.T) {
+	chdirTemp(t)
+
+	transport := &fakeTransport{errs: map[string]error{
+		"jane.smith@example.com": &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"},
+	}}
+
+	q, err := newExampleQueue(
+		WithTransport(transport),
+		WithWorkers(5),
+		WithRateMinute(20),
+		WithJournal("journal.csv"),
+		WithDeliveryLog("deliveries.jsonl"),
+		WithCampaign("test"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := len(q.receivers)
+
+	if err := q.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(transport.sent) != total-1 {
+		t.Fatalf("expected %d emails to be sent, got: %d", total-1, len(transport.sent))
+	}
+	if len(q.failed) != 1 || q.failed[0].Email != "jane.smith@example.com" {
+		t.Fatalf("expected a single failed receiver, got: %+v", q.failed)
+	}
+	if q.errorCount != 0 {
+		t.Fatalf("expected recipient errors not to count against the sender, got: %d", q.errorCount)
+	}
+
+	entries, err := mailer.ReadFile[JournalEntry]("journal.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != total {
+		t.Fatalf("expected %d journal entries, got: %d", total, len(entries))
+	}
+
+	retry, err := mailer.ReadFile[mailer.Receiver]("errored_receivers.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retry) != 1 || retry[0].Email != "jane.smith@example.com" || retry[0].Variables.Data()["name"] != "Jane Smith" {
+		t.Fatalf("expected only the failed receiver to be saved, got: %+v", retry)
+	}
+	failures, err := mailer.ReadFile[FailedReceiver]("errored_receivers.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := failures[0]; f.Sender == "" || f.Code != 550 || f.Error != "5.1.1 User unknown" || f.Attempts != 1 {
+		t.Fatalf("unexpected failure: %+v", f)
+	}
+
+	deliveries, err := ReadDeliveryLog("deliveries.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != total {
+		t.Fatalf("expected %d delivery log entries, got: %d", total, len(deliveries))
+	}
+	ids := make(map[string]bool)
+	for _, d := range deliveries {
+		if d.Campaign != "test" || d.Attempt != 1 || d.MessageID == "" || ids[d.MessageID] {
+			t.Fatalf("unexpected delivery log entry: %+v", d)
+		}
+		ids[d.MessageID] = true
+
+		switch d.Receiver {
+		case "jane.smith@example.com":
+			if d.Status != failed || d.Code != 550 || d.Sent != nil {
+				t.Fatalf("unexpected failed entry: %+v", d)
+			}
+		default:
+			if d.Status != delivered || d.Code != 250 || d.Response != "2.0.0 OK" || d.Sent == nil {
+				t.Fatalf("unexpected delivered entry: %+v", d)
+			}
+		}
+	}
+}
+
+func TestRunWithConnectionFailure(t *testing.T) {
+	chdirTemp(t)
+
+	transport := &fakeTransport{down: map[string]bool{"down@example.com": true}}
+	q := newTestQueue(t, 4, WithTransport(transport), WithRateMinute(5), withSenders("down@example.com", "up@example.com"))
+
+	if err := q.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the receivers of the unreachable sender are sent by the other one
+	if len(transport.sent) != 4 || len(q.failed) != 0 {
+		t.Fatalf("expected every receiver to be sent, got: %v sent, %d failed", transport.sent, len(q.failed))
+	}
+	if q.status["down@example.com"].Failed != 0 || q.status["up@example.com"].Total != 4 {
+		t.Fatalf("unexpected stats: %+v, %+v", q.status["down@example.com"], q.status["up@example.com"])
+	}
+}
+
+func TestDeliveryLogKeepsCampaigns(t *testing.T) {
+	chdirTemp(t)
+
+	for _, campaign := range []string{"spring", "summer"} {
+		q := newTestQueue(t, 1, WithCampaign(campaign), WithDeliveryLog("deliveries.jsonl"))
+		if err := q.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ReadDeliveryLog("deliveries.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verp, err := mailer.ParseVERP("bounces+{{hash}}@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		verp.Register(e.Receiver, e.Campaign)
+	}
+
+	// a late bounce of the first campaign can still be attributed
+	if _, campaign, ok := verp.Decode(verp.Address("0@example.com", "spring")); !ok || campaign != "spring" {
+		t.Fatalf("expected the first campaign to be kept in the delivery log, got: %d entries", len(entries))
+	}
+}
+
+func TestRunRetryKeepsMessageID(t *testing.T) {
+	chdirTemp(t)
+
+	transport := &fakeTransport{errs: map[string]error{
+		"jane.smith@example.com": &textproto.Error{Code: 421, Msg: "4.7.0 Try again later"},
+	}}
+
+	q, err := newExampleQueue(
+		WithTransport(transport),
+		WithRateMinute(20),
+		WithRetries(2, time.Millisecond),
+		WithDeliveryLog("deliveries.jsonl"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err := ReadDeliveryLog("deliveries.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, d := range deliveries {
+		if d.Receiver == "jane.smith@example.com" {
+			ids = append(ids, d.MessageID)
+		}
+	}
+	if len(ids) != 2 || ids[0] == "" || ids[0] != ids[1] {
+		t.Fatalf("expected both attempts to share a Message-ID, got: %q", ids)
+	}
+}
+
+func TestCreateEmailsMessageID(t *testing.T) {
+	task := &task{
+		sender:     &mailer.Sender{Email: "sender@example.com"},
+		receivers:  []*mailer.Receiver{{Email: "a@example.com"}, {Email: "b@example.com"}},
+		messageIDs: []string{"<1.a@example.com>", ""},
+		text:       template.Must(template.New("text").Parse("Hello")),
+	}
+
+	emails, errs := createEmails(task, "sender@example.com")
+	if errs[0] != nil || emails[0].Headers.Get("Message-Id") != "<1.a@example.com>" {
+		t.Fatalf("expected the assigned Message-ID to be used, got: %v", errs[0])
+	}
+	if errs[1] == nil {
+		t.Fatal("expected an email without a Message-ID to fail rather than get a new one")
+	}
+}
+
+func TestRunContextCancelled(t *testing.T) {
+	chdirTemp(t)
+
+	transport := &fakeTransport{}
+	q := newTestQueue(t, 1, WithTransport(transport))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := q.RunContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+	if len(transport.sent) != 0 {
+		t.Fatalf("expected no emails to be sent, got: %d", len(transport.sent))
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	chdirTemp(t)
+
+	q, err := newExampleQueue(
+		WithWorkers(4),
+		WithRateMinute(1),
+		WithRateDaily(1),
+		WithDryRun("dry-run", false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total, start := len(q.receivers), q.clock.Now()
+
+	if err := q.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	emails, err := filepath.Glob("dry-run/*.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != total {
+		t.Fatalf("expected %d emails to be written, got: %d", total, len(emails))
+	}
+
+	plan, err := mailer.ReadFile[PlanEntry]("dry-run/plan.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != total {
+		t.Fatalf("expected %d planned emails, got: %d", total, len(plan))
+	}
+
+	// there are fewer senders than receivers, so the daily limit
+	// must push the last emails to the following day
+	y, m, d := start.Date()
+	if last := plan[len(plan)-1].Time; last.Before(time.Date(y, m, d+1, 0, 0, 0, 0, start.Location())) {
+		t.Fatalf("expected the last email to be scheduled the next day, got: %v", last)
+	}
+}
+
+func TestDryRunOptions(t *testing.T) {
+	chdirTemp(t)
+
+	// a journal set after the dry run is still dropped
+	q, err := newExampleQueue(WithDryRun("dry-run", false), WithJournal("journal.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.journalFile != "" {
+		t.Fatalf("expected a dry run to write no journal, got: %q", q.journalFile)
+	}
+
+	if err := os.WriteFile("journal.csv", []byte("time,status,sender,receiver\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newExampleQueue(WithResume("journal.csv"), WithDryRun("dry-run", false)); err == nil {
+		t.Fatal("expected a resumed dry run to be rejected")
+	}
+}
+
+func TestDryRunMbox(t *testing.T) {
+	chdirTemp(t)
+
+	run := func() {
+		t.Helper()
+		q := newTestQueue(t, 2, WithDryRun("dry-run", true))
+		q.transport = q.defaultTransport()
+
+		if err := q.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a second run replaces the emails of the first
+	run()
+	run()
+
+	b, err := os.ReadFile(filepath.Join("dry-run", mboxFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count("\n"+string(b), "\nFrom "); n != 2 {
+		t.Fatalf("expected 2 emails in the mbox file, got: %d", n)
+	}
+}
+
+func TestCreateEmailsUnsubscribe(t *testing.T) {
+	config := &unsubscribe.Config{BaseURL: "https://example.com/unsubscribe", Secret: []byte("secret")}
+	variables := &mailer.Variables{}
+	if err := variables.UnmarshalCSV("name=Sarah"); err != nil {
+		t.Fatal(err)
+	}
+
+	task := &task{
+		sender:      &mailer.Sender{Email: "sender@example.com"},
+		receivers:   []*mailer.Receiver{{Email: "sarah@example.com", Variables: variables}},
+		text:        template.Must(template.New("text").Parse("Bye {{.name}}: {{.unsubscribe_url}}")),
+		unsubscribe: config,
+	}
+
+	emails, errs := createEmails(task, "sender@example.com")
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+
+	link := config.URL("sarah@example.com")
+	if got := string(emails[0].Text); got != "Bye Sarah: "+link {
+		t.Fatalf("unexpected text: %s", got)
+	}
+	if got := emails[0].Headers.Get("List-Unsubscribe"); got != "<"+link+">" {
+		t.Fatalf("unexpected List-Unsubscribe: %s", got)
+	}
+	if got := emails[0].Headers.Get("List-Unsubscribe-Post"); got != "List-Unsubscribe=One-Click" {
+		t.Fatalf("unexpected List-Unsubscribe-Post: %s", got)
+	}
+	if _, ok := variables.Data()["unsubscribe_url"]; ok {
+		t.Fatal("expected the receiver's variables to be left unchanged")
+	}
+}
+
+func TestCreateEmailsEscapesHTML(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "body.html")
+	body := `<p title="{{.name}}">Hi {{.name}}</p><a href="{{.link}}">link</a>{{raw .signature}}`
+	if err := os.WriteFile(file, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	q := defaultQueue()
+	if err := WithHTML(file)(q); err != nil {
+		t.Fatal(err)
+	}
+
+	variables := &mailer.Variables{}
+	csv := `name=<script>alert("x")</script>;link=javascript:alert(1);signature=<b>Team</b>`
+	if err := variables.UnmarshalCSV(csv); err != nil {
+		t.Fatal(err)
+	}
+
+	task := &task{
+		sender:    &mailer.Sender{Email: "sender@example.com"},
+		receivers: []*mailer.Receiver{{Email: "sarah@example.com", Variables: variables}},
+		text:      template.Must(template.New("text").Parse("Hi {{.name}}")),
+		html:      q.html,
+	}
+
+	emails, errs := createEmails(task, "sender@example.com")
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<p title="&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;">Hi &lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</p>` +
+		`<a href="#ZgotmplZ">link</a><b>Team</b>`
+	if got := string(emails[0].HTML); got != want {
+		t.Fatalf("unexpected html:\n%s\nwant:\n%s", got, want)
+	}
+	// the plaintext body is not escaped
+	if got := string(emails[0].Text); got != `Hi <script>alert("x")</script>` {
+		t.Fatalf("unexpected text: %s", got)
+	}
+}
+
+func TestCreateEmailsSubjectAndPreheader(t *testing.T) {
+	variables := &mailer.Variables{}
+	if err := variables.UnmarshalCSV("name=Sarah;location=Paris\r\nBcc: eve@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	task := &task{
+		sender:    &mailer.Sender{Email: "sender@example.com"},
+		receivers: []*mailer.Receiver{{Email: "sarah@example.com", Variables: variables}},
+		subject:   template.Must(template.New("subject").Parse("Hi {{.name}}, your order from {{.location}}")),
+		preheader: template.Must(template.New("preheader").Parse("{{.name}}, it's on its way")),
+		text:      template.Must(template.New("text").Parse("Hello")),
+		html:      htmltemplate.Must(htmltemplate.New("html").Parse("<body><p>Hello</p></body>")),
+	}
+
+	emails, errs := createEmails(task, "sender@example.com")
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := emails[0].Subject; got != "Hi Sarah, your order from Paris Bcc: eve@example.com" {
+		t.Fatalf("unexpected subject: %q", got)
+	}
+	if got := string(emails[0].HTML); !strings.Contains(got, ">Sarah, it&#39;s on its way</div><p>Hello</p>") {
+		t.Fatalf("expected the preheader at the start of the body, got: %s", got)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/gocarina/gocsv"
+	"github.com/rs/zerolog/log"
+)
+
+// historyWindow is how long sends are remembered for, which covers the
+// longest day a daily quota can count.
+const historyWindow = 48 * time.Hour
+
+// ParseQuotaReset parses the name of the way in which daily quotas are
+// reset, reporting whether it is "rolling" rather than "calendar".
+func ParseQuotaReset(name string) (rolling bool, err error) {
+	switch name {
+	case "", "calendar":
+		return false, nil
+	case "rolling":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown quota reset: %q", name)
+	}
+}
+
+// dailyQuota counts the sends of a sender against its daily limit, either
+// per calendar day in loc, resetting at midnight, or over a rolling
+// window of 24 hours.
+type dailyQuota struct {
+	limit   uint
+	rolling bool
+	loc     *time.Location
+	// sends holds the times of the sends in the current window, in order
+	sends []time.Time
+}
+
+func newDailyQuota(limit uint, rolling bool, loc *time.Location) *dailyQuota {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &dailyQuota{limit: limit, rolling: rolling, loc: loc}
+}
+
+// start returns the start of the window which contains now.
+func (d *dailyQuota) start(now time.Time) time.Time {
+	if d.rolling {
+		return now.Add(-24 * time.Hour)
+	}
+	y, m, day := now.In(d.loc).Date()
+	return time.Date(y, m, day, 0, 0, 0, 0, d.loc)
+}
+
+// count drops the sends which fall before the window containing now,
+// and returns the number of the remaining ones. A rolling window does
+// not include its start, so that a send leaves it exactly 24 hours later.
+func (d *dailyQuota) count(now time.Time) uint {
+	start := d.start(now)
+	i := 0
+	for i < len(d.sends) && (d.sends[i].Before(start) || d.rolling && d.sends[i].Equal(start)) {
+		i++
+	}
+	d.sends = d.sends[i:]
+	return uint(len(d.sends))
+}
+
+func (d *dailyQuota) available(now time.Time) uint {
+	if n := d.count(now); n < d.limit {
+		return d.limit - n
+	}
+	return 0
+}
+
+func (d *dailyQuota) wait(now time.Time) time.Duration {
+	n := d.count(now)
+	if n < d.limit {
+		return 0
+	}
+
+	if d.rolling {
+		// wait for enough of the oldest sends to leave the window
+		return d.sends[n-d.limit].Add(24 * time.Hour).Sub(now)
+	}
+	y, m, day := now.In(d.loc).Date()
+	return time.Date(y, m, day+1, 0, 0, 0, 0, d.loc).Sub(now)
+}
+
+func (d *dailyQuota) take(at time.Time, n uint) {
+	for i := uint(0); i < n; i++ {
+		d.sends = append(d.sends, at)
+	}
+	if len(d.sends) > int(n) && at.Before(d.sends[len(d.sends)-int(n)-1]) {
+		slices.SortFunc(d.sends, func(a, b time.Time) int { return a.Compare(b) })
+	}
+}
+
+func (d *dailyQuota) refund(n uint) {
+	d.sends = d.sends[:len(d.sends)-int(min(n, uint(len(d.sends))))]
+}
+
+// recentSends returns the recent deliveries of a resumed run together
+// with the sends of the quota log, in order. Deliveries which are in both
+// are only returned once: as a run delivers to each receiver at most
+// once, a delivery of the run is matched with an entry of the quota log
+// for the same sender and receiver.
+func (q *Queue) recentSends() []*JournalEntry {
+	type key struct{ sender, receiver string }
+	logged := make(map[key]int, len(q.sent))
+	for _, e := range q.sent {
+		logged[key{e.Sender, e.Receiver}]++
+	}
+
+	sends := slices.Clone(q.sent)
+	for _, e := range q.history {
+		k := key{e.Sender, e.Receiver}
+		if logged[k] > 0 {
+			logged[k]--
+			continue
+		}
+		sends = append(sends, e)
+	}
+
+	slices.SortStableFunc(sends, func(a, b *JournalEntry) int { return a.Time.Compare(b.Time) })
+	return sends
+}
+
+// openQuotaLog rewrites the quota log with the recent sends it was read
+// with, and opens it for appending.
+func (q *Queue) openQuotaLog() (*journal, error) {
+	j, err := openJournal(q.quotaFile, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(q.sent) > 0 {
+		if err := gocsv.MarshalWithoutHeaders(q.sent, j.file); err != nil {
+			j.close()
+			return nil, err
+		}
+	}
+
+	log.Debug().Str("file", q.quotaFile).Int("sends", len(q.sent)).Msg("opened quota log")
+	return j, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+)
+
+func TestDailyQuota(t *testing.T) {
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	now := time.Date(2024, 5, 1, 22, 0, 0, 0, loc)
+
+	calendar := newDailyQuota(2, false, loc)
+	calendar.take(now.Add(-20*time.Hour), 1)
+	calendar.take(now, 1)
+	if n := calendar.available(now); n != 0 {
+		t.Fatalf("expected the calendar quota to be exhausted, got: %d", n)
+	}
+	if d := calendar.wait(now); d != 2*time.Hour {
+		t.Fatalf("expected the calendar quota to reset at midnight, got: %v", d)
+	}
+	// it is already the next day in UTC, but not in loc
+	if n := calendar.available(now.Add(30 * time.Minute)); n != 0 {
+		t.Fatalf("expected the quota to be counted in the configured timezone, got: %d", n)
+	}
+	if n := calendar.available(now.Add(2 * time.Hour)); n != 2 {
+		t.Fatalf("expected the calendar quota to reset, got: %d", n)
+	}
+
+	rolling := newDailyQuota(2, true, loc)
+	rolling.take(now.Add(-20*time.Hour), 1)
+	rolling.take(now, 1)
+	if d := rolling.wait(now); d != 4*time.Hour {
+		t.Fatalf("expected the oldest send to leave the window after 4h, got: %v", d)
+	}
+	if n := rolling.available(now.Add(4 * time.Hour)); n != 1 {
+		t.Fatalf("expected the rolling quota to free a single send, got: %d", n)
+	}
+}
+
+func TestQuotaLog(t *testing.T) {
+	chdirTemp(t)
+
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	run := func(now time.Time, receivers int) *Queue {
+		t.Helper()
+		q := newTestQueue(t, receivers, withClock(now), WithQuotaLog("quota.csv"))
+		q.limits = Limits{PerDay: 3, Location: loc}
+		if err := q.Run(); err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}
+
+	// two days ago, which is dropped from the log
+	run(time.Date(2024, 4, 29, 12, 0, 0, 0, loc), 3)
+
+	start := time.Date(2024, 5, 1, 21, 0, 0, 0, loc)
+	run(start, 2)
+
+	// the sends of the first run on the same day leave room for 1 more,
+	// so the second has to wait for midnight
+	q := run(start.Add(30*time.Minute), 2)
+	if now := q.clock.Now(); !now.Equal(time.Date(2024, 5, 2, 0, 0, 0, 0, loc)) {
+		t.Fatalf("expected the run to wait for the quota to reset at midnight, got: %v", now)
+	}
+
+	entries, err := mailer.ReadFile[JournalEntry]("quota.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected the sends of the last two runs to be logged, got: %d", len(entries))
+	}
+}
+
+func TestQuotaLogResume(t *testing.T) {
+	chdirTemp(t)
+
+	limits := Limits{PerDay: 4, Rolling: true}
+
+	// the run is interrupted after its first 2 sends
+	q := newTestQueue(t, 2, WithJournal("journal.csv"), WithQuotaLog("quota.csv"))
+	q.limits = limits
+	if err := q.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	q = newTestQueue(t, 6, WithResume("journal.csv"), WithQuotaLog("quota.csv"))
+	q.limits = limits
+	q.newLimiters()
+
+	// the sends are in both the journal and the quota log, but only
+	// count once
+	if n := q.limiters["sender@example.com"].available(q.clock.Now()); n != 2 {
+		t.Fatalf("expected 2 sends to be available, got: %d", n)
+	}
+}
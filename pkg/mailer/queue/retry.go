@@ -0,0 +1,102 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+)
+
+// maxBackoff is the longest delay before a retry.
+const maxBackoff = 1 * time.Hour
+
+// retry represents a receiver whose email is to be re-sent after a
+// transient failure.
+type retry struct {
+	receiver *mailer.Receiver
+	at       time.Time
+}
+
+// backoff returns the delay before the given attempt, growing exponentially
+// from the base delay of the Queue, with up to half of it randomised.
+func (q *Queue) backoff(attempt uint8) time.Duration {
+	d := q.retryDelay << (attempt - 1)
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// retry schedules the email to a receiver to be re-sent after a transient
+// failure. It reports false if the receiver has run out of attempts.
+func (q *Queue) retry(receiver *mailer.Receiver) bool {
+	q.attempts[receiver]++
+	attempt := q.attempts[receiver]
+	if attempt >= q.maxAttempts {
+		return false
+	}
+
+	q.retries = append(q.retries, &retry{
+		receiver: receiver,
+		at:       q.clock.Now().Add(q.backoff(attempt)),
+	})
+	return true
+}
+
+// assignMessageIDs returns the Message-ID of the email to each of
+// receivers, generating one in the domain of sender for those which do
+// not have one yet, so that an email keeps its Message-ID when it is
+// retried. An ID which cannot be generated is left empty, for the email
+// to fail when it is created.
+func (q *Queue) assignMessageIDs(sender *mailer.Sender, receivers []*mailer.Receiver) []string {
+	ids := make([]string, len(receivers))
+	for i, receiver := range receivers {
+		id, ok := q.messageIDs[receiver]
+		if !ok {
+			var err error
+			if id, err = mailer.NewMessageID(sender.Email); err != nil {
+				continue
+			}
+			q.messageIDs[receiver] = id
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+// enqueueRetries moves the retries which are due back onto the list of
+// receivers, and returns the time at which the next retry is due.
+func (q *Queue) enqueueRetries() (next time.Time) {
+	now := q.clock.Now()
+	pending := q.retries[:0]
+
+	for _, r := range q.retries {
+		if !r.at.After(now) {
+			q.receivers = append(q.receivers, r.receiver)
+			continue
+		}
+		if next.IsZero() || r.at.Before(next) {
+			next = r.at
+		}
+		pending = append(pending, r)
+	}
+	q.retries = pending
+
+	return next
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+)
+
+func TestRetry(t *testing.T) {
+	q := defaultQueue()
+	q.maxAttempts = 3
+	q.retryDelay = time.Minute
+	receiver := &mailer.Receiver{Email: "a@example.com"}
+
+	for i := 1; i < int(q.maxAttempts); i++ {
+		if !q.retry(receiver) {
+			t.Fatalf("expected attempt %d to be retried", i)
+		}
+
+		r := q.retries[len(q.retries)-1]
+		earliest := time.Now().Add(q.retryDelay << (i - 1) / 2)
+		if r.at.Before(earliest.Add(-time.Second)) {
+			t.Fatalf("expected attempt %d to be delayed until at least %v, got %v", i, earliest, r.at)
+		}
+	}
+
+	if q.retry(receiver) {
+		t.Fatal("expected receiver to have run out of attempts")
+	}
+
+	if next := q.enqueueRetries(); next.IsZero() || len(q.receivers) != 0 {
+		t.Fatal("expected retries to not be due yet")
+	}
+
+	for _, r := range q.retries {
+		r.at = time.Now()
+	}
+	if next := q.enqueueRetries(); !next.IsZero() || len(q.receivers) != 2 {
+		t.Fatalf("expected due retries to be enqueued, got: %d", len(q.receivers))
+	}
+}
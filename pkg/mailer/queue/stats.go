@@ -14,43 +14,28 @@
 
 package queue
 
-import "time"
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+	"github.com/gocarina/gocsv"
+)
 
 // Stats represents a unique senders statistics including
 // total emails successfully sent, emails that have failed to
 // send as well as bounces.
 type Stats struct {
 	skip    bool
-	today   uint
-	timeout *time.Time
 	Sender  string `csv:"sender"`
 	Total   uint   `csv:"total"`
 	Failed  uint   `csv:"failed"`
 	Bounced uint   `csv:"bounced"`
 }
 
-func (s *Stats) isTimedOut() bool {
-	if s.timeout == nil {
-		return false
-	}
-
-	now := time.Now()
-
-	if now.Unix() > s.timeout.Unix() {
-		s.timeout = nil
-		return false
-	}
-
-	return true
-}
-
-func (s *Stats) setTimeout(d time.Duration) {
-	t := time.Now().Add(d)
-	s.timeout = &t
-}
-
 func (s *Stats) increment(num uint) {
-	s.today += num
 	s.Total += num
 }
 
@@ -61,3 +46,67 @@ func (s *Stats) incrementFailed(num uint) {
 func (s *Stats) incrementBounced(num uint) {
 	s.Bounced += num
 }
+
+// RecordBounces adds the number of bounces of each sender to the stats
+// saved in file by a previous run, adding rows for senders which are not
+// in it yet. Senders are matched case insensitively.
+func RecordBounces(file string, bounces map[string]uint) error {
+	stats, err := mailer.ReadFile[Stats](file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	status := make(map[string]*Stats, len(stats))
+	for _, s := range stats {
+		status[strings.ToLower(s.Sender)] = s
+	}
+	for sender, n := range bounces {
+		s, ok := status[strings.ToLower(sender)]
+		if !ok {
+			s = &Stats{Sender: sender}
+			status[strings.ToLower(sender)] = s
+			stats = append(stats, s)
+		}
+		s.incrementBounced(n)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return gocsv.MarshalFile(stats, f)
+}
+
+// saveStats saves the stats of the run to file. The bounces recorded in
+// it by [RecordBounces] are carried over, rather than lost when a run
+// overwrites the stats of a previous one.
+func (q *Queue) saveStats(file string) error {
+	prev, err := mailer.ReadFile[Stats](file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, gocsv.ErrEmptyCSVFile) {
+		return err
+	}
+
+	stats := make([]*Stats, 0, len(q.status))
+	status := make(map[string]*Stats, len(q.status))
+	for _, s := range mapToSlice(q.status) {
+		c := *s
+		stats = append(stats, &c)
+		status[strings.ToLower(s.Sender)] = &c
+	}
+	for _, p := range prev {
+		if p.Bounced == 0 {
+			continue
+		}
+		s, ok := status[strings.ToLower(p.Sender)]
+		if !ok {
+			s = &Stats{Sender: p.Sender}
+			status[strings.ToLower(p.Sender)] = s
+			stats = append(stats, s)
+		}
+		s.incrementBounced(p.Bounced)
+	}
+
+	return SaveResults[Stats](stats, file)
+}
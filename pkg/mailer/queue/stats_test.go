@@ -15,6 +15,8 @@
 package queue
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gocarina/gocsv"
@@ -22,9 +24,7 @@ import (
 
 func TestStats(t *testing.T) {
 	s := &Stats{
-		timeout: nil,
 		skip:    false,
-		today:   10,
 		Sender:  "random.email@provider.com",
 		Total:   10,
 		Failed:  7,
@@ -41,3 +41,46 @@ func TestStats(t *testing.T) {
 		t.Fatalf("expected:\n%s\ngot:\n%s", expected, csv)
 	}
 }
+
+func TestRecordBounces(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "stats.csv")
+	if err := os.WriteFile(file, []byte("sender,total,failed,bounced\nSender@example.com,10,1,2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	bounces := map[string]uint{"sender@example.com": 3, "other@example.com": 1}
+	if err := RecordBounces(file, bounces); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "sender,total,failed,bounced\nSender@example.com,10,1,5\nother@example.com,0,0,1\n"
+	if string(b) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, b)
+	}
+}
+
+func TestSaveStatsKeepsBounces(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "stats.csv")
+	if err := os.WriteFile(file, []byte("sender,total,failed,bounced\nSender@example.com,10,1,2\nold@example.com,4,0,1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	q := defaultQueue()
+	q.status["sender@example.com"] = &Stats{Sender: "sender@example.com", Total: 5}
+	if err := q.saveStats(file); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "sender,total,failed,bounced\nsender@example.com,5,0,2\nold@example.com,0,0,1\n"
+	if string(b) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, b)
+	}
+}
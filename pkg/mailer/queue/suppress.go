@@ -0,0 +1,57 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/suppress"
+	"github.com/rs/zerolog/log"
+)
+
+// suppress drops the receivers of the Queue whose addresses are in list,
+// along with any such Cc and Bcc addresses. Dropped receivers are kept
+// in q.suppressed, so that they can be reported at the end of the run.
+func (q *Queue) suppress(list *suppress.List) {
+	drop := func(address string) bool {
+		e, ok := list.Get(address)
+		if ok {
+			log.Info().Str("email", address).Str("reason", e.Reason).Msg("suppressed")
+		}
+		return ok
+	}
+
+	receivers := q.receivers[:0]
+	var copies int
+	for _, r := range q.receivers {
+		if drop(r.Email) {
+			q.suppressed = append(q.suppressed, r)
+			continue
+		}
+		if r.Cc != nil {
+			copies += len(r.Cc.Remove(drop))
+		}
+		if r.Bcc != nil {
+			copies += len(r.Bcc.Remove(drop))
+		}
+		receivers = append(receivers, r)
+	}
+	q.receivers = receivers
+
+	if len(q.suppressed) > 0 || copies > 0 {
+		log.Warn().
+			Int("receivers", len(q.suppressed)).
+			Int("copies", copies).
+			Msg("dropped suppressed addresses")
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/suppress"
+)
+
+func TestSuppress(t *testing.T) {
+	list, err := suppress.Open(filepath.Join(t.TempDir(), "suppressions.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list.Add("john@example.com", suppress.Unsubscribed, time.Now())
+	list.Add("boss@example.com", suppress.Bounced, time.Now())
+
+	cc := &mailer.List{}
+	if err := cc.UnmarshalCSV("Boss@example.com;team@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	q := defaultQueue()
+	q.receivers = []*mailer.Receiver{
+		{Email: "sarah@example.com", Cc: cc},
+		{Email: "John@Example.com"},
+	}
+	q.suppress(list)
+
+	if len(q.receivers) != 1 || q.receivers[0].Email != "sarah@example.com" {
+		t.Fatalf("expected only sarah@example.com to remain, got: %+v", q.receivers)
+	}
+	if got := q.receivers[0].Cc.Data(); len(got) != 1 || got[0] != "team@example.com" {
+		t.Fatalf("expected the suppressed cc to be dropped, got: %v", got)
+	}
+	if len(q.suppressed) != 1 || q.suppressed[0].Email != "John@Example.com" {
+		t.Fatalf("expected john to be reported as suppressed, got: %+v", q.suppressed)
+	}
+}
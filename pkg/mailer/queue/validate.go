@@ -0,0 +1,277 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"maps"
+	"slices"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Problem describes a receiver whose variables do not match those
+// referenced by the templates of a Queue.
+type Problem struct {
+	// Row is the position of the receiver in its file, starting at 1
+	// for the first row after the header, or 0 if it is not known.
+	Row      int
+	Receiver string
+	// Missing are the variables referenced by the templates which the
+	// receiver does not have.
+	Missing []string
+	// Unused are the variables of the receiver which are not referenced
+	// by any template.
+	Unused []string
+}
+
+// Validate compares the variables referenced by the templates of the
+// Queue, and by the attachments of each receiver, with the variables of
+// that receiver. It returns a Problem for each receiver which is missing
+// variables, or has unused ones.
+func (q *Queue) Validate() []Problem {
+	fields := make(map[string]bool)
+	for _, t := range []*template.Template{q.subject, q.preheader, q.text} {
+		if t != nil {
+			textFields(t, fields)
+		}
+	}
+	if q.html != nil {
+		htmlFields(q.html, fields)
+	}
+
+	provided := make(map[string]bool)
+	if q.unsubscribe != nil && q.unsubscribe.BaseURL != "" {
+		provided["unsubscribe_url"] = true
+	}
+
+	var problems []Problem
+	for _, r := range q.receivers {
+		var vars map[string]string
+		if r.Variables != nil {
+			vars = r.Variables.Data()
+		}
+
+		used := maps.Clone(fields)
+		if r.Attachments != nil {
+			for _, path := range r.Attachments.Data() {
+				if t, err := template.New("attachment").Funcs(funcs).Parse(path); err == nil {
+					textFields(t, used)
+				}
+			}
+		}
+
+		p := Problem{Row: q.rows[r], Receiver: r.Email}
+		for field, required := range used {
+			if _, ok := vars[field]; !ok && required && !provided[field] {
+				p.Missing = append(p.Missing, field)
+			}
+		}
+		for v := range vars {
+			if _, ok := used[v]; !ok {
+				p.Unused = append(p.Unused, v)
+			}
+		}
+
+		if len(p.Missing) > 0 || len(p.Unused) > 0 {
+			slices.Sort(p.Missing)
+			slices.Sort(p.Unused)
+			problems = append(problems, p)
+		}
+	}
+
+	return problems
+}
+
+// preflight validates the Queue before it is run, failing if any
+// receiver is missing variables, or if the campaign-wide attachments
+// exceed the size limit on their own, rather than failing for each
+// receiver once their emails are created.
+func (q *Queue) preflight() error {
+	var size int64
+	for _, a := range q.attachments {
+		size += int64(len(a.content))
+	}
+	if q.maxAttachmentSize > 0 && size > q.maxAttachmentSize {
+		return fmt.Errorf("attachments are %d bytes, exceeding the limit of %d bytes", size, q.maxAttachmentSize)
+	}
+
+	var missing int
+	for _, p := range q.Validate() {
+		if len(p.Unused) > 0 {
+			log.Debug().Int("row", p.Row).Str("receiver", p.Receiver).Strs("variables", p.Unused).Msg("unused variables")
+		}
+		if len(p.Missing) > 0 {
+			log.Error().Int("row", p.Row).Str("receiver", p.Receiver).Strs("variables", p.Missing).Msg("missing variables")
+			missing++
+		}
+	}
+
+	if missing > 0 {
+		return fmt.Errorf("%d receivers are missing variables referenced by the templates", missing)
+	}
+	return nil
+}
+
+// textFields adds the variables referenced by t, and the templates it
+// includes, to fields. A variable is required, rather than optional, if
+// it is referenced as a field, such as .name, and not only with index,
+// as in index . "name".
+func textFields(t *template.Template, fields map[string]bool) {
+	lookup := func(name string) *parse.Tree {
+		if t := t.Lookup(name); t != nil {
+			return t.Tree
+		}
+		return nil
+	}
+	w := &fieldWalker{lookup: lookup, fields: fields, visited: make(map[string]bool)}
+	w.template(t.Name())
+}
+
+// htmlFields is like [textFields] for HTML templates.
+func htmlFields(t *htmltemplate.Template, fields map[string]bool) {
+	lookup := func(name string) *parse.Tree {
+		if t := t.Lookup(name); t != nil {
+			return t.Tree
+		}
+		return nil
+	}
+	w := &fieldWalker{lookup: lookup, fields: fields, visited: make(map[string]bool)}
+	w.template(t.Name())
+}
+
+// fieldWalker collects the fields of the data of a template which are
+// referenced by its parse tree. Fields are only collected where dot is
+// the data itself, which excludes the bodies of range and with actions.
+// Fields read with index are collected as optional, since index does not
+// fail for missing keys.
+type fieldWalker struct {
+	lookup  func(name string) *parse.Tree
+	fields  map[string]bool
+	visited map[string]bool
+}
+
+func (w *fieldWalker) template(name string) {
+	if w.visited[name] {
+		return
+	}
+	w.visited[name] = true
+
+	if tree := w.lookup(name); tree != nil && tree.Root != nil {
+		w.walk(tree.Root, true)
+	}
+}
+
+func (w *fieldWalker) add(field string, required bool) {
+	w.fields[field] = w.fields[field] || required
+}
+
+func (w *fieldWalker) walk(node parse.Node, root bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, node := range n.Nodes {
+			w.walk(node, root)
+		}
+	case *parse.ActionNode:
+		w.walk(n.Pipe, root)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			w.walk(cmd, root)
+		}
+	case *parse.CommandNode:
+		if field, ok := indexed(n, root); ok {
+			w.add(field, false)
+		}
+		for _, arg := range n.Args {
+			w.walk(arg, root)
+		}
+	case *parse.ChainNode:
+		w.walk(n.Node, root)
+	case *parse.FieldNode:
+		if root {
+			w.add(n.Ident[0], true)
+		}
+	case *parse.VariableNode:
+		// $ is the data of the template, wherever dot is
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			w.add(n.Ident[1], true)
+		}
+	case *parse.IfNode:
+		w.walk(n.Pipe, root)
+		w.walk(n.List, root)
+		w.walk(n.ElseList, root)
+	case *parse.RangeNode:
+		w.walk(n.Pipe, root)
+		w.walk(n.List, false)
+		w.walk(n.ElseList, root)
+	case *parse.WithNode:
+		w.walk(n.Pipe, root)
+		w.walk(n.List, false)
+		w.walk(n.ElseList, root)
+	case *parse.TemplateNode:
+		w.walk(n.Pipe, root)
+		// the included template only sees the data if it is passed dot
+		if root && isDot(n.Pipe) {
+			w.template(n.Name)
+		}
+	}
+}
+
+// indexed returns the field read by cmd, if it is of the form
+// index . "field" or index $ "field".
+func indexed(cmd *parse.CommandNode, root bool) (string, bool) {
+	if len(cmd.Args) != 3 {
+		return "", false
+	}
+	if fn, ok := cmd.Args[0].(*parse.IdentifierNode); !ok || fn.Ident != "index" {
+		return "", false
+	}
+
+	switch data := cmd.Args[1].(type) {
+	case *parse.DotNode:
+		if !root {
+			return "", false
+		}
+	case *parse.VariableNode:
+		if len(data.Ident) != 1 || data.Ident[0] != "$" {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+
+	key, ok := cmd.Args[2].(*parse.StringNode)
+	if !ok {
+		return "", false
+	}
+	return key.Text, true
+}
+
+func isDot(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	_, ok := pipe.Cmds[0].Args[0].(*parse.DotNode)
+	return ok
+}
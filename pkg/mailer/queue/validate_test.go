@@ -0,0 +1,100 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	htmltemplate "html/template"
+	"os"
+	"reflect"
+	"testing"
+	"text/template"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer/unsubscribe"
+)
+
+func receiver(t *testing.T, email, variables string) *mailer.Receiver {
+	t.Helper()
+	r := &mailer.Receiver{Email: email, Variables: &mailer.Variables{}}
+	if err := r.Variables.UnmarshalCSV(variables); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestValidate(t *testing.T) {
+	text := template.Must(template.New("text").Funcs(funcs).Parse(
+		`Dear {{.name}},{{range .items}}{{.price}}{{end}}{{with .vip}}{{$.perk}}{{end}}{{template "footer" .}}{{template "sign"}}{{index . "nickname" | default "friend"}}`,
+	))
+	template.Must(text.New("footer").Parse(`{{.company}}`))
+	template.Must(text.New("sign").Parse(`{{.ignored}}`))
+	html := htmltemplate.Must(htmltemplate.New("html").Parse(`<p>{{if .location}}{{.location}}{{end}} {{.unsubscribe_url}}</p>`))
+
+	q := defaultQueue()
+	q.text, q.html = text, html
+	q.unsubscribe = &unsubscribe.Config{BaseURL: "https://example.com/unsubscribe", Secret: []byte("secret")}
+	q.receivers = []*mailer.Receiver{
+		receiver(t, "john@example.com", "name=John;items=3;vip=yes;perk=lounge;company=Hermes;location=Paris"),
+		receiver(t, "jane@example.com", "name=Jane;items=1;vip=no;perk=none;company=Hermes;location=Rome;nickname=JJ;age=30"),
+		receiver(t, "mark@example.com", "name=Mark;company=Hermes"),
+	}
+
+	expected := []Problem{
+		{Receiver: "jane@example.com", Unused: []string{"age"}},
+		{Receiver: "mark@example.com", Missing: []string{"items", "location", "perk", "vip"}},
+	}
+	if problems := q.Validate(); !reflect.DeepEqual(problems, expected) {
+		t.Fatalf("expected:\n%+v\ngot:\n%+v", expected, problems)
+	}
+
+	if err := q.preflight(); err == nil {
+		t.Fatal("expected receivers with missing variables to fail the preflight check")
+	}
+}
+
+func TestValidateRows(t *testing.T) {
+	chdirTemp(t)
+	csv := "email,variables\n" +
+		"john@example.com,name=John\n" +
+		"jane@example.com,\n" +
+		"mark@example.com,name=Mark;age=30\n"
+	if err := os.WriteFile("receivers.csv", []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	q := defaultQueue()
+	if err := q.load("", "receivers.csv"); err != nil {
+		t.Fatal(err)
+	}
+	q.text = template.Must(template.New("text").Parse("Dear {{.name}}"))
+	// the first receiver is dropped, as a suppressed one would be
+	q.receivers = q.receivers[1:]
+
+	problems := q.Validate()
+	if len(problems) != 2 || problems[0].Row != 2 || problems[1].Row != 3 {
+		t.Fatalf("expected the rows of the receivers in their file, got: %+v", problems)
+	}
+}
+
+func TestCreateEmailsMissingVariable(t *testing.T) {
+	task := &task{
+		sender:    &mailer.Sender{Email: "sender@example.com"},
+		receivers: []*mailer.Receiver{receiver(t, "mark@example.com", "location=London")},
+		text:      template.Must(template.New("text").Option("missingkey=error").Parse("Dear {{.name}}")),
+	}
+	if _, errs := createEmails(task, "sender@example.com"); errs[0] == nil {
+		t.Fatal("expected a missing variable to fail rather than render <no value>")
+	}
+}
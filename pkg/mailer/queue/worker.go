@@ -15,7 +15,11 @@
 package queue
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"net/textproto"
 	"strings"
 	"sync"
@@ -41,64 +45,169 @@ type workerResult struct {
 	sender    string
 	error     error
 	sent      uint
+	delivered []*mailer.Receiver
+	// receivers which could not be sent to, along with the reason
 	receivers []*mailer.Receiver
+	errs      []error
+	// the delivery log entry of each email which was created
+	deliveries map[*mailer.Receiver]*DeliveryEntry
 }
 
-func createEmails(task *task, from string) ([]*email.Email, error) {
+// fail records that receiver could not be sent to because of err.
+func (r *workerResult) fail(receiver *mailer.Receiver, err error) {
+	if r.kind == success {
+		r.kind = failure
+		r.error = err
+	}
+	r.receivers = append(r.receivers, receiver)
+	r.errs = append(r.errs, err)
+}
+
+// errMessage marks the errors of emails which could not be created, such
+// as those of receivers with a missing attachment. They concern a single
+// receiver, rather than the sender.
+var errMessage = errors.New("could not create email")
+
+// createEmails creates the email of each receiver of task. The email of
+// a receiver is nil if it could not be created, in which case its error
+// is set instead. Message-IDs are generated for a task which was not
+// given any by its Queue.
+func createEmails(task *task, from string) ([]*email.Email, []error) {
 	log.Debug().Str("sender", task.sender.Email).Msg("creating emails")
-	emails := make([]*email.Email, 0, len(task.receivers))
-
-	for _, receiver := range task.receivers {
-		e := &email.Email{
-			From:    from,
-			To:      []string{receiver.Email},
-			Subject: task.subject,
-			Headers: make(textproto.MIMEHeader),
+	emails := make([]*email.Email, len(task.receivers))
+	errs := make([]error, len(task.receivers))
+
+	for i, receiver := range task.receivers {
+		var id string
+		var err error
+		if task.messageIDs != nil {
+			id = task.messageIDs[i]
+		} else if id, err = mailer.NewMessageID(task.sender.Email); err != nil {
+			errs[i] = fmt.Errorf("%w: %w", errMessage, err)
+			continue
 		}
 
-		if receiver.Bcc != nil {
-			e.Cc = receiver.Cc.Data()
+		e, err := createEmail(task, receiver, id, from)
+		if err != nil {
+			errs[i] = fmt.Errorf("%w: %w", errMessage, err)
+			continue
 		}
+		emails[i] = e
+	}
 
-		if receiver.Bcc != nil {
-			e.Bcc = receiver.Bcc.Data()
+	return emails, errs
+}
+
+// createEmail creates the email to receiver, with the Message-ID id. It
+// fails if id is empty, rather than give a retried email a new one.
+func createEmail(task *task, receiver *mailer.Receiver, id, from string) (*email.Email, error) {
+	e := &email.Email{
+		From:    from,
+		To:      []string{receiver.Email},
+		Headers: make(textproto.MIMEHeader),
+	}
+
+	if id == "" {
+		return nil, fmt.Errorf("no Message-ID could be generated for %q", receiver.Email)
+	}
+	e.Headers.Set("Message-Id", id)
+
+	if task.verp != nil {
+		e.Sender = task.verp.Address(receiver.Email, task.campaign)
+	}
+
+	if receiver.Cc != nil {
+		e.Cc = receiver.Cc.Data()
+	}
+
+	if receiver.Bcc != nil {
+		e.Bcc = receiver.Bcc.Data()
+	}
+
+	data := make(map[string]string)
+	if receiver.Variables != nil {
+		maps.Copy(data, receiver.Variables.Data())
+	}
+
+	if task.unsubscribe != nil {
+		list, post := task.unsubscribe.Headers(receiver.Email)
+		e.Headers.Set("List-Unsubscribe", list)
+		if post != "" {
+			e.Headers.Set("List-Unsubscribe-Post", post)
 		}
+		if _, ok := data["unsubscribe_url"]; !ok && task.unsubscribe.BaseURL != "" {
+			data["unsubscribe_url"] = task.unsubscribe.URL(receiver.Email)
+		}
+	}
 
-		var data map[string]string
-		if receiver.Variables != nil {
-			data = receiver.Variables.Data()
-		} else {
-			data = make(map[string]string)
+	if task.subject != nil {
+		subject, err := render(task.subject, data)
+		if err != nil {
+			return nil, err
 		}
+		// a variable must not be able to break the header
+		e.Subject = strings.Join(strings.Fields(subject), " ")
+	}
 
-		text := new(strings.Builder)
-		err := task.text.Execute(text, data)
+	text, err := render(task.text, data)
+	if err != nil {
+		return nil, err
+	}
+	e.Text = []byte(text)
+
+	if task.html != nil {
+		html, err := render(task.html, data)
 		if err != nil {
 			return nil, err
 		}
-		e.Text = []byte(text.String())
+		e.HTML = []byte(html)
 
-		if task.html != nil {
-			html := new(strings.Builder)
-			err := task.html.Execute(html, data)
+		if task.preheader != nil {
+			preheader, err := render(task.preheader, data)
 			if err != nil {
 				return nil, err
 			}
-			e.HTML = []byte(html.String())
+			e.HTML = insertPreheader(e.HTML, preheader)
 		}
 
-		if task.readReceipt != "" {
-			e.Headers.Add("Disposition-Notification-To", task.readReceipt)
-			e.Headers.Add("Return-Receipt-To", task.readReceipt)
+		if task.embedImages {
+			if e.HTML, err = embedImages(e, e.HTML, task.htmlDir); err != nil {
+				return nil, err
+			}
 		}
+	}
 
-		emails = append(emails, e)
+	var paths []string
+	if receiver.Attachments != nil {
+		paths = receiver.Attachments.Data()
+	}
+	if err := attach(e, task, paths, data); err != nil {
+		return nil, err
 	}
 
-	return emails, nil
+	if task.readReceipt != "" {
+		e.Headers.Add("Disposition-Notification-To", task.readReceipt)
+		e.Headers.Add("Return-Receipt-To", task.readReceipt)
+	}
+
+	return e, nil
+}
+
+// executor is implemented by both text and HTML templates.
+type executor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// render executes t with data, returning the output as a string.
+func render(t executor, data map[string]string) (string, error) {
+	b := new(strings.Builder)
+	if err := t.Execute(b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
 }
 
-func worker(task *task, auth mailer.Auth, res chan workerResult, wg *sync.WaitGroup) {
+func worker(ctx context.Context, task *task, transport mailer.Transport, res chan workerResult, wg *sync.WaitGroup) {
 	log.Debug().
 		Str("sender", task.sender.Email).
 		Int("receivers", len(task.receivers)).
@@ -113,17 +222,56 @@ func worker(task *task, auth mailer.Auth, res chan workerResult, wg *sync.WaitGr
 		from = task.sender.Email
 	}
 
-	emails, err := createEmails(task, from)
-	if err != nil {
-		res <- workerResult{kind: failure, sender: task.sender.Email, error: err, receivers: task.receivers}
-		return
+	emails, errs := createEmails(task, from)
+
+	result := workerResult{
+		kind:       success,
+		sender:     task.sender.Email,
+		deliveries: make(map[*mailer.Receiver]*DeliveryEntry, len(emails)),
 	}
 
-	idx, err := mailer.SendEmailsTLS(task.sender, emails, task.host, auth)
-	if err != nil {
-		res <- workerResult{kind: failure, sender: task.sender.Email, error: err, receivers: task.receivers[idx:]}
+	// only the emails which could be created are sent
+	var receivers []*mailer.Receiver
+	var created []*email.Email
+	for i, e := range emails {
+		if e == nil {
+			result.fail(task.receivers[i], errs[i])
+			continue
+		}
+		receivers = append(receivers, task.receivers[i])
+		created = append(created, e)
+	}
+	if len(created) == 0 {
+		res <- result
 		return
 	}
 
-	res <- workerResult{kind: success, sender: task.sender.Email, sent: uint(len(task.receivers) - idx)}
+	for i, r := range transport.Send(ctx, task.sender, created) {
+		entry := &DeliveryEntry{
+			Campaign:  task.campaign,
+			Sender:    task.sender.Email,
+			Receiver:  receivers[i].Email,
+			MessageID: created[i].Headers.Get("Message-Id"),
+			Created:   task.created,
+			Code:      r.Code,
+			Response:  r.Response,
+		}
+		if !r.Time.IsZero() {
+			entry.Sent = &r.Time
+		}
+		if r.Err != nil {
+			serr := mailer.ClassifyError(r.Err)
+			entry.Code, entry.Response = serr.Code, serr.Msg
+		}
+		result.deliveries[receivers[i]] = entry
+
+		if r.Err == nil {
+			result.sent++
+			result.delivered = append(result.delivered, receivers[i])
+			continue
+		}
+		result.fail(receivers[i], r.Err)
+	}
+
+	res <- result
 }
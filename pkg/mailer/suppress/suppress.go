@@ -0,0 +1,194 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package suppress implements a persistent list of email addresses
+// which must never be sent to again, such as those of receivers who
+// have unsubscribed or whose emails have hard bounced.
+package suppress
+
+import (
+	"errors"
+	"io/fs"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocarina/gocsv"
+)
+
+const (
+	// Manual is the reason for addresses suppressed by hand
+	Manual = "manual"
+	// Unsubscribed is the reason for addresses which have opted out
+	Unsubscribed = "unsubscribe"
+	// Bounced is the reason for addresses which have hard bounced
+	Bounced = "bounce"
+	// Complaint is the reason for addresses which have reported spam
+	Complaint = "complaint"
+)
+
+// Entry represents a single suppressed address.
+type Entry struct {
+	Email  string    `csv:"email"`
+	Reason string    `csv:"reason"`
+	Added  time.Time `csv:"added"`
+}
+
+// List is a set of suppressed addresses backed by a CSV file with the
+// columns email, reason and added. Addresses are matched case
+// insensitively. It is safe for concurrent use.
+type List struct {
+	file    string
+	mu      sync.Mutex
+	entries map[string]*Entry
+}
+
+// Normalize returns the canonical form of an address, as stored in a
+// List, stripping any display name and lower-casing it.
+func Normalize(address string) string {
+	address = strings.TrimSpace(address)
+	if a, err := mail.ParseAddress(address); err == nil {
+		address = a.Address
+	}
+	return strings.ToLower(address)
+}
+
+// Open reads the suppression list stored in file. A missing file is
+// treated as an empty list, which is created once the list is saved.
+func Open(file string) (*List, error) {
+	l := &List{file: file, entries: make(map[string]*Entry)}
+
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []*Entry
+	if err := gocsv.UnmarshalFile(f, &entries); err != nil && !errors.Is(err, gocsv.ErrEmptyCSVFile) {
+		return nil, err
+	}
+	for _, e := range entries {
+		e.Email = Normalize(e.Email)
+		l.entries[e.Email] = e
+	}
+
+	return l, nil
+}
+
+// Add suppresses address for the given reason, reporting whether it was
+// not already suppressed. The reason of an existing entry is kept.
+func (l *List) Add(address, reason string, now time.Time) bool {
+	address = Normalize(address)
+	if address == "" {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.entries[address]; ok {
+		return false
+	}
+	l.entries[address] = &Entry{Email: address, Reason: reason, Added: now.UTC().Truncate(time.Second)}
+	return true
+}
+
+// Remove lifts the suppression of address, reporting whether it was
+// suppressed.
+func (l *List) Remove(address string) bool {
+	address = Normalize(address)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.entries[address]; !ok {
+		return false
+	}
+	delete(l.entries, address)
+	return true
+}
+
+// Get returns the entry of address, if it is suppressed.
+func (l *List) Get(address string) (*Entry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[Normalize(address)]
+	return e, ok
+}
+
+// Contains reports whether address is suppressed.
+func (l *List) Contains(address string) bool {
+	_, ok := l.Get(address)
+	return ok
+}
+
+// Len returns the number of suppressed addresses.
+func (l *List) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.entries)
+}
+
+// Entries returns the suppressed addresses, sorted by address.
+func (l *List) Entries() []*Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]*Entry, 0, len(l.entries))
+	for _, e := range l.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Email < entries[j].Email })
+	return entries
+}
+
+// Save writes the list back to its file. The file is replaced
+// atomically, so that a concurrent reader never sees a partial list.
+func (l *List) Save() error {
+	entries := l.Entries()
+
+	dir := filepath.Dir(l.file)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(l.file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := gocsv.Marshal(entries, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), l.file)
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suppress
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "suppressions.csv")
+
+	l, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Len() != 0 {
+		t.Fatalf("expected a missing file to be an empty list, got: %d entries", l.Len())
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if !l.Add("Sarah Parker <Sarah@Example.com>", Unsubscribed, now) {
+		t.Fatal("expected a new address to be added")
+	}
+	if l.Add("sarah@example.com", Bounced, now) {
+		t.Fatal("expected a duplicate address not to be added")
+	}
+	l.Add("john@example.com", Manual, now)
+	if err := l.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Contains("SARAH@example.com") {
+		t.Fatal("expected the address to be suppressed case insensitively")
+	}
+
+	entries := l.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got: %d", len(entries))
+	}
+	if e := entries[1]; e.Email != "sarah@example.com" || e.Reason != Unsubscribed || !e.Added.Equal(now) {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+
+	if !l.Remove("john@example.com") || l.Remove("john@example.com") {
+		t.Fatal("expected the address to be removed exactly once")
+	}
+	if l.Contains("john@example.com") {
+		t.Fatal("expected the address to no longer be suppressed")
+	}
+}
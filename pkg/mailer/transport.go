@@ -0,0 +1,120 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jordan-wright/email"
+)
+
+// ErrNotSent is reported for email messages which were not attempted,
+// typically because an earlier message in the same batch failed.
+var ErrNotSent = errors.New("email message not sent")
+
+// ErrConnection is reported, along with [ErrNotSent], for every message
+// of a batch when the connection to the server, or the login of the
+// sender, fails. It concerns the sender rather than any of the receivers.
+var ErrConnection = errors.New("could not connect to smtp server")
+
+// Result represents the outcome of sending a single email message.
+type Result struct {
+	// Err is nil if the email message was sent successfully
+	Err error
+	// Code and Response are the reply of the server to the message,
+	// if it was accepted
+	Code     int
+	Response string
+	// Time is when the message was sent, or zero if it was not
+	Time time.Time
+}
+
+// Transport is the interface implemented by the mechanisms which
+// deliver email messages on behalf of a [Sender].
+type Transport interface {
+	// Send sends a batch of email messages from sender, and returns
+	// a [Result] for each of them, in the same order.
+	Send(ctx context.Context, sender *Sender, emails []*email.Email) []Result
+}
+
+// SMTPTransport is the default [Transport], which sends each batch of
+// email messages over a single SMTP connection.
+type SMTPTransport struct {
+	// Host is the SMTP host address
+	Host string
+	// Port is the SMTP port, 587 by default
+	Port     uint16
+	Security Security
+	// Auth is the authentication mechanism used to log in to Host
+	Auth Auth
+	// OAuth2 is used to obtain access tokens for the XOAUTH2 and
+	// OAUTHBEARER mechanisms. If nil, the password of each sender is
+	// used as its access token
+	OAuth2 *OAuth2Config
+	// TLSConfig is used for STARTTLS and implicit TLS connections
+	TLSConfig *tls.Config
+	// Timeout limits the time taken to connect and to send each message
+	Timeout time.Duration
+	// Signer, if set, signs each message before it is sent
+	Signer Signer
+
+	// tokens caches a *RefreshTokenSource for each sender
+	tokens *sync.Map
+}
+
+// NewSMTPTransport constructs an instance of [mailer.SMTPTransport]
+// which connects to port 587 of host, using STARTTLS if it is supported.
+func NewSMTPTransport(host string, auth Auth) *SMTPTransport {
+	return &SMTPTransport{
+		Host:     host,
+		Port:     587,
+		Security: StartTLS,
+		Auth:     auth,
+		Timeout:  10 * time.Second,
+		tokens:   new(sync.Map),
+	}
+}
+
+// Send implements [Transport]. The server settings of sender, if any,
+// take precedence over those of the transport. Once a message fails to
+// send, or ctx is done, the remaining messages of the batch are reported
+// as [ErrNotSent], as are all of them if the connection fails.
+func (t *SMTPTransport) Send(ctx context.Context, sender *Sender, emails []*email.Email) []Result {
+	results := make([]Result, len(emails))
+
+	idx, err := t.forSender(sender).send(ctx, sender, emails, results)
+	if err == nil {
+		return results
+	}
+
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) || errors.Is(err, ErrConnection) {
+		err = fmt.Errorf("%w: %w", ErrNotSent, err)
+	} else {
+		results[idx].Err = err
+		idx++
+		err = ErrNotSent
+	}
+
+	for i := idx; i < len(results); i++ {
+		results[i].Err = err
+	}
+
+	return results
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/sink"
+	"github.com/jordan-wright/email"
+)
+
+// startSink starts a local sink server, returning it along with the
+// port it listens on and a TLS configuration which trusts it.
+func startSink(t *testing.T, starttls, implicit bool) (*sink.Server, uint16, *tls.Config) {
+	t.Helper()
+
+	cert, pem, err := sink.SelfSignedCert("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pem)
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if implicit {
+		ln = tls.NewListener(ln, serverConfig)
+	}
+
+	var srvTLS *tls.Config
+	if starttls {
+		srvTLS = serverConfig
+	}
+	srv := sink.NewServer(ln.Addr().String(), t.TempDir(), sink.EML, srvTLS)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- srv.Serve(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	_, p, _ := net.SplitHostPort(ln.Addr().String())
+	port, _ := strconv.ParseUint(p, 10, 16)
+	return srv, uint16(port), &tls.Config{RootCAs: pool}
+}
+
+func testEmails() []*email.Email {
+	return []*email.Email{
+		{From: "Sender <sender@example.com>", To: []string{"a@example.com"}, Subject: "Hello", Text: []byte("Hello, A!")},
+		{From: "Sender <sender@example.com>", To: []string{"b@example.com"}, Cc: []string{"c@example.com"}, Subject: "Hello", Text: []byte("Hello, B!")},
+	}
+}
+
+func TestSMTPTransport(t *testing.T) {
+	tests := []struct {
+		name               string
+		starttls, implicit bool
+		security           Security
+		fail               bool
+	}{
+		{"starttls", true, false, StartTLS, false},
+		{"starttls opportunistic", false, false, StartTLS, false},
+		{"starttls required", true, false, StartTLSRequired, false},
+		{"starttls required unsupported", false, false, StartTLSRequired, true},
+		{"implicit tls", false, true, ImplicitTLS, false},
+		{"none", true, false, NoTLS, false},
+	}
+
+	sender := &Sender{Email: "sender@example.com", Password: "password"}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv, port, tlsConfig := startSink(t, test.starttls, test.implicit)
+
+			transport := NewSMTPTransport("localhost", Plain)
+			transport.Port = port
+			transport.Security = test.security
+			transport.TLSConfig = tlsConfig
+
+			results := transport.Send(context.Background(), sender, testEmails())
+			if test.fail {
+				for _, r := range results {
+					if !errors.Is(r.Err, ErrNotSent) || !errors.Is(r.Err, ErrConnection) {
+						t.Fatalf("expected every email to be unsent, got: %v", r.Err)
+					}
+				}
+				return
+			}
+
+			for _, r := range results {
+				if r.Err != nil {
+					t.Fatal(r.Err)
+				}
+				if r.Code != 250 || r.Response != "2.0.0 OK" || r.Time.IsZero() {
+					t.Fatalf("unexpected reply: %d %q at %v", r.Code, r.Response, r.Time)
+				}
+			}
+
+			summary := srv.Summary()
+			if summary.Messages != 2 || summary.Recipients != 3 {
+				t.Fatalf("unexpected summary: %+v", summary)
+			}
+		})
+	}
+}
+
+func TestSMTPTransportSenderOverrides(t *testing.T) {
+	srv, port, _ := startSink(t, false, false)
+
+	transport := NewSMTPTransport("unreachable.invalid", Plain)
+	transport.Security = StartTLSRequired
+
+	sender := &Sender{
+		Email:    "sender@example.com",
+		Password: "password",
+		Host:     "localhost",
+		Port:     port,
+		Auth:     "login",
+		Security: "none",
+		Username: "sender",
+	}
+	if err := sender.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range transport.Send(context.Background(), sender, testEmails()) {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	}
+
+	if summary := srv.Summary(); summary.Messages != 2 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+}
+
+func TestSMTPTransportFailedEmail(t *testing.T) {
+	_, port, _ := startSink(t, false, false)
+
+	transport := NewSMTPTransport("localhost", Plain)
+	transport.Port = port
+	transport.Security = NoTLS
+
+	emails := testEmails()
+	emails[1].From = "not an address"
+
+	sender := &Sender{Email: "sender@example.com", Password: "password"}
+	results := transport.Send(context.Background(), sender, emails)
+	if results[0].Err != nil || results[0].Time.IsZero() {
+		t.Fatalf("expected the first email to be sent, got: %+v", results[0])
+	}
+	if results[1].Err == nil || !results[1].Time.IsZero() {
+		t.Fatalf("expected the second email to fail without a send time, got: %+v", results[1])
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package unsubscribe implements signed, per-receiver unsubscribe links,
+// the List-Unsubscribe headers which carry them, and an HTTP handler
+// which supports RFC 8058 one-click unsubscription.
+package unsubscribe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidToken is returned for tokens which were not signed with the
+// secret of the [Config], or which are malformed.
+var ErrInvalidToken = errors.New("invalid unsubscribe token")
+
+// tokenParam is the query parameter which carries the token.
+const tokenParam = "t"
+
+// Config describes how unsubscribe links are built and verified.
+type Config struct {
+	// BaseURL is the public URL at which [Config.Handler] is served,
+	// e.g. "https://example.com/unsubscribe".
+	BaseURL string
+	// Mailto optionally sets an address to which unsubscribe requests
+	// may also be emailed, for clients which do not support HTTPS links.
+	Mailto string
+	// Secret is the key with which tokens are signed.
+	Secret []byte
+}
+
+func (c *Config) mac(address string) []byte {
+	h := hmac.New(sha256.New, c.Secret)
+	h.Write([]byte(strings.ToLower(address)))
+	return h.Sum(nil)[:16]
+}
+
+// Token returns the signed token identifying address.
+func (c *Config) Token(address string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(address)) + "." + enc.EncodeToString(c.mac(address))
+}
+
+// Verify checks that token was signed with the secret of c, returning
+// the address it identifies.
+func (c *Config) Verify(token string) (string, error) {
+	enc := base64.RawURLEncoding
+
+	a, m, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	address, err := enc.DecodeString(a)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	mac, err := enc.DecodeString(m)
+	if err != nil || !hmac.Equal(mac, c.mac(string(address))) {
+		return "", ErrInvalidToken
+	}
+
+	return string(address), nil
+}
+
+// URL returns the unsubscribe link of address.
+func (c *Config) URL(address string) string {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	q.Set(tokenParam, c.Token(address))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Headers returns the values of the List-Unsubscribe and
+// List-Unsubscribe-Post headers for an email sent to address. The latter
+// is empty unless the BaseURL is an HTTPS one.
+func (c *Config) Headers(address string) (list, post string) {
+	var links []string
+	if c.BaseURL != "" {
+		links = append(links, "<"+c.URL(address)+">")
+	}
+	if c.Mailto != "" {
+		links = append(links, fmt.Sprintf("<mailto:%s?subject=unsubscribe:%s>", c.Mailto, c.Token(address)))
+	}
+
+	// RFC 8058 one-click unsubscription requires an HTTPS link.
+	if u, err := url.Parse(c.BaseURL); err == nil && strings.EqualFold(u.Scheme, "https") {
+		post = "List-Unsubscribe=One-Click"
+	}
+	return strings.Join(links, ", "), post
+}
+
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body>
+{{- if .Done }}
+<p>{{ .Email }} has been unsubscribed.</p>
+{{- else }}
+<form method="post">
+<p>Unsubscribe {{ .Email }}?</p>
+<button type="submit">Unsubscribe</button>
+</form>
+{{- end }}
+</body>
+</html>
+`))
+
+// Handler returns an HTTP handler for the links built by c. GET requests
+// show a confirmation page, so that link scanners do not unsubscribe
+// receivers, while POST requests, including the one-click requests of
+// RFC 8058, call unsubscribe with the verified address.
+func (c *Config) Handler(unsubscribe func(address string) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		address, err := c.Verify(r.URL.Query().Get(tokenParam))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data := struct {
+			Email string
+			Done  bool
+		}{Email: address}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost:
+			if err := unsubscribe(address); err != nil {
+				http.Error(w, "could not unsubscribe", http.StatusInternalServerError)
+				return
+			}
+			data.Done = true
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		page.Execute(w, data)
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unsubscribe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestToken(t *testing.T) {
+	c := &Config{Secret: []byte("secret")}
+
+	token := c.Token("sarah@example.com")
+	address, err := c.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != "sarah@example.com" {
+		t.Fatalf("expected: sarah@example.com, got: %s", address)
+	}
+
+	forged := (&Config{Secret: []byte("other")}).Token("sarah@example.com")
+	for _, token := range []string{"", "garbage", forged, strings.Replace(token, ".", "x.", 1)} {
+		if _, err := c.Verify(token); err == nil {
+			t.Fatalf("expected token %q to be rejected", token)
+		}
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	c := &Config{BaseURL: "https://example.com/unsubscribe", Mailto: "unsubscribe@example.com", Secret: []byte("secret")}
+
+	list, post := c.Headers("sarah@example.com")
+	token := c.Token("sarah@example.com")
+	want := "<https://example.com/unsubscribe?t=" + token + ">, <mailto:unsubscribe@example.com?subject=unsubscribe:" + token + ">"
+	if list != want {
+		t.Fatalf("expected: %s\ngot: %s", want, list)
+	}
+	if post != "List-Unsubscribe=One-Click" {
+		t.Fatalf("unexpected List-Unsubscribe-Post: %q", post)
+	}
+
+	c.BaseURL = "http://example.com/unsubscribe"
+	if _, post := c.Headers("sarah@example.com"); post != "" {
+		t.Fatalf("expected no one-click header without an https url, got: %q", post)
+	}
+
+	c.BaseURL = ""
+	if _, post := c.Headers("sarah@example.com"); post != "" {
+		t.Fatalf("expected no one-click header without a url, got: %q", post)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	c := &Config{BaseURL: "http://localhost/unsubscribe", Secret: []byte("secret")}
+
+	var unsubscribed []string
+	h := c.Handler(func(address string) error {
+		unsubscribed = append(unsubscribed, address)
+		return nil
+	})
+
+	link := c.URL("sarah@example.com")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, link, nil))
+	if rec.Code != http.StatusOK || len(unsubscribed) != 0 {
+		t.Fatalf("expected GET to only confirm, got: %d, %v", rec.Code, unsubscribed)
+	}
+
+	body := url.Values{"List-Unsubscribe": {"One-Click"}}.Encode()
+	req := httptest.NewRequest(http.MethodPost, link, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || len(unsubscribed) != 1 || unsubscribed[0] != "sarah@example.com" {
+		t.Fatalf("expected a one-click POST to unsubscribe, got: %d, %v", rec.Code, unsubscribed)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://localhost/unsubscribe?t=forged", nil))
+	if rec.Code != http.StatusBadRequest || len(unsubscribed) != 1 {
+		t.Fatalf("expected a forged token to be rejected, got: %d, %v", rec.Code, unsubscribed)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mailer
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"strings"
+)
+
+// hashPlaceholder is replaced by the hash of the receiver and campaign
+// in VERP templates.
+const hashPlaceholder = "{{hash}}"
+
+// maxLocalPart is the maximum length of the local part of an address,
+// as set by RFC 5321.
+const maxLocalPart = 64
+
+// hashSize is the number of bytes of the hash which are encoded into
+// envelope senders, making for 16 characters.
+const hashSize = 10
+
+// verpEncoding is a case-insensitive encoding whose characters are
+// all valid in the local part of an address.
+var verpEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// VERP builds variable envelope return paths, which give every email
+// its own envelope sender, so that a bounce can be attributed to the
+// receiver and campaign of the email which bounced.
+//
+// Envelope senders only hold a short hash of the receiver and campaign,
+// as the local part of an address is limited to 64 characters. Bounces
+// are attributed by resolving the hash against the receivers which were
+// sent to, as given to [VERP.Register].
+type VERP struct {
+	prefix, suffix string
+	sent           map[string]verpEntry
+}
+
+type verpEntry struct {
+	receiver, campaign string
+}
+
+// ParseVERP parses a template for envelope senders, such as
+// "bounces+{{hash}}@example.com", in which "{{hash}}" is replaced by the
+// hash of the receiver and campaign. The placeholder must appear exactly
+// once, in the local part of the address, which must leave room for it.
+func ParseVERP(template string) (*VERP, error) {
+	prefix, suffix, ok := strings.Cut(template, hashPlaceholder)
+	if !ok || strings.Contains(suffix, hashPlaceholder) {
+		return nil, fmt.Errorf("verp template %q must contain %s exactly once", template, hashPlaceholder)
+	}
+	if strings.Contains(prefix, "@") || !strings.Contains(suffix, "@") {
+		return nil, fmt.Errorf("verp template %q must have %s in its local part", template, hashPlaceholder)
+	}
+
+	hashLen := verpEncoding.EncodedLen(hashSize)
+	if n := len(prefix) + hashLen + strings.Index(suffix, "@"); n > maxLocalPart {
+		return nil, fmt.Errorf("verp template %q makes for a local part of %d characters, which is limited to %d", template, n, maxLocalPart)
+	}
+	return &VERP{prefix: prefix, suffix: suffix}, nil
+}
+
+// hash returns the encoded hash of receiver and campaign.
+func hash(receiver, campaign string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(receiver) + "\x00" + campaign))
+	return verpEncoding.EncodeToString(sum[:hashSize])
+}
+
+// Address returns the envelope sender for an email to receiver, sent as
+// part of campaign.
+func (v *VERP) Address(receiver, campaign string) string {
+	return v.prefix + hash(receiver, campaign) + v.suffix
+}
+
+// Register records that an email was sent to receiver as part of
+// campaign, so that its envelope sender can be decoded.
+func (v *VERP) Register(receiver, campaign string) {
+	if v.sent == nil {
+		v.sent = make(map[string]verpEntry)
+	}
+	v.sent[hash(receiver, campaign)] = verpEntry{strings.ToLower(receiver), campaign}
+}
+
+// Decode returns the receiver and campaign of the envelope sender
+// address, reporting whether address was built by v for a registered
+// receiver.
+func (v *VERP) Decode(address string) (receiver, campaign string, ok bool) {
+	address = strings.ToLower(strings.Trim(strings.TrimSpace(address), "<>"))
+	prefix, suffix := strings.ToLower(v.prefix), strings.ToLower(v.suffix)
+	if !strings.HasPrefix(address, prefix) || !strings.HasSuffix(address, suffix) || len(address) < len(prefix)+len(suffix) {
+		return "", "", false
+	}
+
+	entry, ok := v.sent[address[len(prefix):len(address)-len(suffix)]]
+	return entry.receiver, entry.campaign, ok
+}
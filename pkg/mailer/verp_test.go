@@ -0,0 +1,74 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mailer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVERP(t *testing.T) {
+	long := strings.Repeat("b", 50) + "+{{hash}}@example.com"
+	for _, tmpl := range []string{"bounces@example.com", "{{hash}}{{hash}}@example.com", "bounces@{{hash}}.example.com", long} {
+		if _, err := ParseVERP(tmpl); err == nil {
+			t.Fatalf("expected template %q to be rejected", tmpl)
+		}
+	}
+
+	v, err := ParseVERP("bounces+{{hash}}@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := v.Address("Sarah@Example.net", "spring-sale")
+	if !strings.HasPrefix(address, "bounces+") || !strings.HasSuffix(address, "@example.com") {
+		t.Fatalf("unexpected address: %s", address)
+	}
+
+	if _, _, ok := v.Decode(address); ok {
+		t.Fatalf("expected %q not to decode before its receiver is registered", address)
+	}
+	v.Register("Sarah@Example.net", "spring-sale")
+
+	// mail systems may change the case of the address
+	receiver, campaign, ok := v.Decode("<" + strings.ToUpper(address) + ">")
+	if !ok || receiver != "sarah@example.net" || campaign != "spring-sale" {
+		t.Fatalf("unexpected decoding: %q, %q, %t", receiver, campaign, ok)
+	}
+
+	for _, address := range []string{"bounces@example.com", "bounces+abc@example.com", "sarah@example.net"} {
+		if _, _, ok := v.Decode(address); ok {
+			t.Fatalf("expected %q not to decode", address)
+		}
+	}
+}
+
+func TestVERPLocalPart(t *testing.T) {
+	v, err := ParseVERP("bounces+{{hash}}@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := v.Address("firstname.lastname@companyname.co.uk", "spring-sale-2024")
+	if local, _, _ := strings.Cut(address, "@"); len(local) > 64 {
+		t.Fatalf("expected a local part of at most 64 characters, got %d: %s", len(local), address)
+	}
+
+	v.Register("firstname.lastname@companyname.co.uk", "spring-sale-2024")
+	receiver, campaign, ok := v.Decode(address)
+	if !ok || receiver != "firstname.lastname@companyname.co.uk" || campaign != "spring-sale-2024" {
+		t.Fatalf("unexpected decoding: %q, %q, %t", receiver, campaign, ok)
+	}
+}
@@ -0,0 +1,427 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sink implements a local SMTP server which accepts every message
+// it receives and stores it on disk, allowing send operations to be
+// rehearsed without delivering any email.
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Summary represents the messages received by a [Server].
+type Summary struct {
+	Messages   uint
+	Recipients uint
+	// Senders maps each envelope sender to the number of
+	// messages received from it
+	Senders map[string]uint
+}
+
+// Server is an SMTP server which accepts any authentication and every
+// message, writing them to Dir.
+type Server struct {
+	// Addr is the TCP address to listen on
+	Addr string
+	// Dir is the directory in which messages are stored
+	Dir    string
+	Format Format
+	// TLSConfig enables the STARTTLS extension if set
+	TLSConfig *tls.Config
+	// ImplicitTLS serves connections over TLS from the start, using
+	// TLSConfig, instead of offering STARTTLS
+	ImplicitTLS bool
+	// Hostname is the name the server greets clients with
+	Hostname string
+
+	mu      sync.Mutex
+	summary Summary
+	conns   map[net.Conn]struct{}
+}
+
+// NewServer constructs an instance of [sink.Server].
+func NewServer(addr, dir string, format Format, tlsConfig *tls.Config) *Server {
+	return &Server{
+		Addr:      addr,
+		Dir:       dir,
+		Format:    format,
+		TLSConfig: tlsConfig,
+		Hostname:  "localhost",
+		summary:   Summary{Senders: make(map[string]uint)},
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Summary returns a summary of the messages received so far.
+func (s *Server) Summary() Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summary := s.summary
+	summary.Senders = make(map[string]uint, len(s.summary.Senders))
+	for k, v := range s.summary.Senders {
+		summary.Senders[k] = v
+	}
+	return summary
+}
+
+// ListenAndServe listens on the TCP address s.Addr and serves
+// incoming connections until ctx is done.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	if s.ImplicitTLS {
+		ln = tls.NewListener(ln, s.TLSConfig)
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts incoming connections on ln until ctx is done, at
+// which point ln and all open connections are closed.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	log.Info().Str("addr", ln.Addr().String()).Str("dir", s.Dir).Msg("sink listening")
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+	}()
+
+	wg := new(sync.WaitGroup)
+	defer wg.Wait()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *Server) record(from string, to []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.summary.Messages++
+	s.summary.Recipients += uint(len(to))
+	s.summary.Senders[from]++
+}
+
+// session represents the state of a single SMTP connection.
+type session struct {
+	srv  *Server
+	conn net.Conn
+	text *textproto.Conn
+	tls  bool
+	user string
+	// mail is set once MAIL has been accepted, as from is empty for the
+	// null reverse-path ("<>") used by bounces.
+	mail bool
+	from string
+	to   []string
+}
+
+func (s *session) reply(code int, format string, args ...any) error {
+	return s.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+func (s *session) reset() {
+	s.mail = false
+	s.from = ""
+	s.to = nil
+}
+
+func (s *Server) handle(conn net.Conn) {
+	_, isTLS := conn.(*tls.Conn)
+	sess := &session{srv: s, conn: conn, text: textproto.NewConn(conn), tls: isTLS}
+	defer sess.text.Close()
+
+	log.Debug().Str("remote", conn.RemoteAddr().String()).Msg("sink accepted connection")
+	if err := sess.reply(220, "%s ESMTP hermes sink", s.Hostname); err != nil {
+		return
+	}
+
+	for {
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Debug().Err(err).Msg("sink connection error")
+			}
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		if err := sess.command(strings.ToUpper(verb), strings.TrimSpace(arg)); err != nil {
+			if !errors.Is(err, errQuit) {
+				log.Debug().Err(err).Msg("sink connection error")
+			}
+			return
+		}
+	}
+}
+
+var errQuit = errors.New("client quit")
+
+func (s *session) command(verb, arg string) error {
+	switch verb {
+	case "EHLO":
+		s.reset()
+		lines := []string{s.srv.Hostname, "PIPELINING", "8BITMIME", "AUTH PLAIN LOGIN XOAUTH2 OAUTHBEARER"}
+		if s.srv.TLSConfig != nil && !s.tls {
+			lines = append(lines, "STARTTLS")
+		}
+		for _, l := range lines[:len(lines)-1] {
+			if err := s.text.PrintfLine("250-%s", l); err != nil {
+				return err
+			}
+		}
+		return s.reply(250, "%s", lines[len(lines)-1])
+
+	case "HELO":
+		s.reset()
+		return s.reply(250, "%s", s.srv.Hostname)
+
+	case "STARTTLS":
+		if s.srv.TLSConfig == nil || s.tls {
+			return s.reply(502, "5.5.1 STARTTLS not available")
+		}
+		if err := s.reply(220, "2.0.0 Ready to start TLS"); err != nil {
+			return err
+		}
+
+		conn := tls.Server(s.conn, s.srv.TLSConfig)
+		if err := conn.Handshake(); err != nil {
+			return err
+		}
+		s.conn = conn
+		s.text = textproto.NewConn(conn)
+		s.tls = true
+		s.reset()
+		return nil
+
+	case "AUTH":
+		return s.auth(arg)
+
+	case "MAIL":
+		addr, ok := parsePath(arg, "FROM:")
+		if !ok {
+			return s.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		}
+		s.reset()
+		s.mail = true
+		s.from = addr
+		return s.reply(250, "2.1.0 OK")
+
+	case "RCPT":
+		if !s.mail {
+			return s.reply(503, "5.5.1 MAIL first")
+		}
+		addr, ok := parsePath(arg, "TO:")
+		if !ok || addr == "" {
+			return s.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		}
+		s.to = append(s.to, addr)
+		return s.reply(250, "2.1.5 OK")
+
+	case "DATA":
+		if len(s.to) == 0 {
+			return s.reply(503, "5.5.1 RCPT first")
+		}
+		if err := s.reply(354, "Start mail input; end with <CRLF>.<CRLF>"); err != nil {
+			return err
+		}
+		return s.data()
+
+	case "RSET":
+		s.reset()
+		return s.reply(250, "2.0.0 OK")
+
+	case "NOOP":
+		return s.reply(250, "2.0.0 OK")
+
+	case "VRFY":
+		return s.reply(252, "2.5.0 Cannot VRFY user")
+
+	case "QUIT":
+		s.reply(221, "2.0.0 Bye")
+		return errQuit
+
+	default:
+		return s.reply(502, "5.5.2 Command not recognized")
+	}
+}
+
+func (s *session) auth(arg string) error {
+	mech, initial, _ := strings.Cut(arg, " ")
+
+	switch strings.ToUpper(mech) {
+	case "PLAIN":
+		resp := initial
+		if resp == "" {
+			var err error
+			if resp, err = s.challenge(""); err != nil {
+				return err
+			}
+		}
+
+		b, err := base64.StdEncoding.DecodeString(resp)
+		if err != nil {
+			return s.reply(501, "5.5.2 Invalid base64 data")
+		}
+		parts := bytes.Split(b, []byte{0})
+		if len(parts) != 3 {
+			return s.reply(501, "5.5.2 Invalid PLAIN credentials")
+		}
+		s.user = string(parts[1])
+
+	case "LOGIN":
+		resp, err := s.challenge("Username:")
+		if err != nil {
+			return err
+		}
+		user, err := base64.StdEncoding.DecodeString(resp)
+		if err != nil {
+			return s.reply(501, "5.5.2 Invalid base64 data")
+		}
+		if _, err := s.challenge("Password:"); err != nil {
+			return err
+		}
+		s.user = string(user)
+
+	case "XOAUTH2", "OAUTHBEARER":
+		resp := initial
+		if resp == "" {
+			var err error
+			if resp, err = s.challenge(""); err != nil {
+				return err
+			}
+		}
+
+		b, err := base64.StdEncoding.DecodeString(resp)
+		if err != nil {
+			return s.reply(501, "5.5.2 Invalid base64 data")
+		}
+		user, ok := oauthUser(string(b))
+		if !ok {
+			return s.reply(501, "5.5.2 Invalid %s credentials", strings.ToUpper(mech))
+		}
+		s.user = user
+
+	default:
+		return s.reply(504, "5.5.4 Unrecognized authentication type")
+	}
+
+	log.Debug().Str("user", s.user).Str("mech", mech).Msg("sink authenticated client")
+	return s.reply(235, "2.7.0 Authentication successful")
+}
+
+func (s *session) challenge(prompt string) (string, error) {
+	if err := s.reply(334, "%s", base64.StdEncoding.EncodeToString([]byte(prompt))); err != nil {
+		return "", err
+	}
+	return s.text.ReadLine()
+}
+
+func (s *session) data() error {
+	data, err := s.text.ReadDotBytes()
+	if err != nil {
+		return err
+	}
+
+	file, err := store(s.srv.Dir, s.srv.Format, s.from, s.to, data)
+	if err != nil {
+		log.Error().Err(err).Msg("sink could not store message")
+		return s.reply(451, "4.3.0 Could not store message")
+	}
+	s.srv.record(s.from, s.to)
+
+	log.Info().
+		Str("from", s.from).
+		Strs("to", s.to).
+		Str("file", file).
+		Msg("sink received message")
+
+	s.reset()
+	return s.reply(250, "2.0.0 OK")
+}
+
+// oauthUser extracts the username from an XOAUTH2 ("user=...") or
+// OAUTHBEARER ("n,a=...,") initial response.
+func oauthUser(resp string) (string, bool) {
+	if rest, ok := strings.CutPrefix(resp, "n,a="); ok {
+		user, _, ok := strings.Cut(rest, ",")
+		return user, ok
+	}
+
+	for _, field := range strings.Split(resp, "\x01") {
+		if user, ok := strings.CutPrefix(field, "user="); ok {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+// parsePath extracts the address from a "FROM:<address>" or "TO:<address>"
+// argument, ignoring any parameters which follow it. The null path "<>"
+// yields an empty address.
+func parsePath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	path := strings.TrimSpace(arg[len(prefix):])
+	start := strings.IndexByte(path, '<')
+	end := strings.IndexByte(path, '>')
+	if start != 0 || end < start {
+		return "", false
+	}
+	return path[1:end], true
+}
@@ -0,0 +1,206 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/abh1sheke/hermes-mailer/pkg/mailer"
+)
+
+func startServer(t *testing.T, format Format) (*Server, string, *x509.CertPool) {
+	t.Helper()
+
+	cert, pem, err := SelfSignedCert("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pem)
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer(ln.Addr().String(), t.TempDir(), format, &tls.Config{Certificates: []tls.Certificate{cert}})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- srv.Serve(ctx, ln) }()
+
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, net.JoinHostPort("localhost", port), pool
+}
+
+func send(t *testing.T, addr string, pool *x509.CertPool, auth smtp.Auth) {
+	t.Helper()
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.StartTLS(&tls.Config{ServerName: "localhost", RootCAs: pool}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Auth(auth); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Mail("sender@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rcpt("receiver@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("Subject: Hello\r\n\r\nHello, world!\r\n.leading dot\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerMaildir(t *testing.T) {
+	srv, addr, pool := startServer(t, Maildir)
+
+	send(t, addr, pool, smtp.PlainAuth("", "sender@example.com", "password", "localhost"))
+	send(t, addr, pool, mailer.LoginAuth("sender@example.com", "password", "localhost"))
+
+	entries, err := os.ReadDir(filepath.Join(srv.Dir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 messages, got: %d", len(entries))
+	}
+
+	b, err := os.ReadFile(filepath.Join(srv.Dir, "new", entries[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(b)
+	for _, s := range []string{"Return-Path: <sender@example.com>", "Delivered-To: receiver@example.com", "Hello, world!", "\n.leading dot"} {
+		if !strings.Contains(msg, s) {
+			t.Fatalf("expected message to contain %q, got:\n%s", s, msg)
+		}
+	}
+
+	summary := srv.Summary()
+	if summary.Messages != 2 || summary.Recipients != 2 || summary.Senders["sender@example.com"] != 2 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+}
+
+func TestServerEML(t *testing.T) {
+	srv, addr, pool := startServer(t, EML)
+
+	send(t, addr, pool, smtp.PlainAuth("", "sender@example.com", "password", "localhost"))
+
+	matches, err := filepath.Glob(filepath.Join(srv.Dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 message, got: %d", len(matches))
+	}
+}
+
+func TestServerNullSender(t *testing.T) {
+	srv, addr, _ := startServer(t, EML)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Mail(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rcpt("receiver@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("Subject: Undelivered Mail\r\n\r\nBounce\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(srv.Dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 message, got: %d", len(matches))
+	}
+	b, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "Return-Path: <>") {
+		t.Fatalf("expected an empty Return-Path, got:\n%s", b)
+	}
+}
+
+func TestOAuthUser(t *testing.T) {
+	for resp, expected := range map[string]string{
+		"user=someone@example.com\x01auth=Bearer token\x01\x01":                   "someone@example.com",
+		"n,a=someone@example.com,\x01host=localhost\x01auth=Bearer token\x01\x01": "someone@example.com",
+	} {
+		user, ok := oauthUser(resp)
+		if !ok || user != expected {
+			t.Fatalf("expected user %q, got: %q", expected, user)
+		}
+	}
+
+	if _, ok := oauthUser("auth=Bearer token"); ok {
+		t.Fatal("expected response without a user to be invalid")
+	}
+}
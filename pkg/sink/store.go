@@ -0,0 +1,94 @@
+// Copyright 2024 Abhisheke Acharya
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Format represents the layout in which the [Server] stores the
+// messages it receives.
+type Format uint8
+
+const (
+	// Maildir stores messages in a Maildir directory (tmp, new and cur)
+	Maildir Format = iota
+	// EML stores each message as a separate .eml file
+	EML
+)
+
+// ParseFormat converts the name of a Format, such as "maildir" or
+// "eml", into a Format.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "maildir":
+		return Maildir, nil
+	case "eml":
+		return EML, nil
+	default:
+		return 0, fmt.Errorf("unknown format: %q, expected 'maildir' or 'eml'", name)
+	}
+}
+
+var seq atomic.Uint64
+
+// store writes a message to dir in the given format, prepending the
+// envelope sender and recipients as headers. It returns the path of the
+// written file.
+func store(dir string, format Format, from string, to []string, data []byte) (string, error) {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "Return-Path: <%s>\n", from)
+	for _, rcpt := range to {
+		fmt.Fprintf(buf, "Delivered-To: %s\n", rcpt)
+	}
+	buf.Write(data)
+
+	now := time.Now()
+	n := seq.Add(1)
+
+	if format == EML {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return "", err
+		}
+		name := filepath.Join(dir, fmt.Sprintf("%s-%04d.eml", now.Format("20060102T150405"), n))
+		return name, os.WriteFile(name, buf.Bytes(), 0o644)
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return "", err
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	unique := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), n, hostname)
+
+	tmp := filepath.Join(dir, "tmp", unique)
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(dir, "new", unique)
+	return name, os.Rename(tmp, name)
+}